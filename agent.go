@@ -0,0 +1,176 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// agentChildEnv is set in the environment of the detached agent, which gets
+// the password on file descriptor 3 and its listener on descriptor 4.
+const agentChildEnv = "GOSEC_AGENT_CHILD"
+
+// agentCommand unlocks the private keys and serves them on the agent socket.
+// Unless -foreground is given, the agent detaches once the keys are unlocked
+// and the socket is listening, and the environment to reach it is printed in
+// the manner of ssh-agent.
+func agentCommand(args []string) int {
+	fs := flag.NewFlagSet("agent", flag.ContinueOnError)
+	ttlPtr := fs.Duration("ttl", time.Hour, "Forget the keys and exit after `DURATION`")
+	socketPtr := fs.String("socket", store.AgentSocketPath(), "Unix socket `PATH`")
+	foregroundPtr := fs.Bool("foreground", false, "Do not detach")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s agent [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if os.Getenv(agentChildEnv) != "" {
+		return runAgentChild(*ttlPtr)
+	}
+
+	l, err := store.ListenAgent(*socketPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	err = ctx.ReadKeyRing()
+	var password string
+	if err == nil {
+		password, err = promptPassword()
+	}
+	var agent *store.Agent
+	if err == nil {
+		agent, err = store.NewAgent(ctx.PrivateRing, password)
+	}
+	if err != nil {
+		l.Close()
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *foregroundPtr {
+		fmt.Fprintf(os.Stderr, "gosec agent listening on %v\n", *socketPtr)
+		return serveAgent(agent, l, *ttlPtr)
+	}
+
+	pid, err := detachAgent(l.(*net.UnixListener), password, *ttlPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("%v=%v; export %v;\n", store.AgentSocketEnv, *socketPtr, store.AgentSocketEnv)
+	fmt.Printf("echo gosec agent pid %d;\n", pid)
+	return 0
+}
+
+// detachAgent starts the agent in a new process, handing it the password and
+// l, and returns its pid.
+func detachAgent(l *net.UnixListener, password string, ttl time.Duration) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	listenerFile, err := l.File()
+	if err != nil {
+		return 0, err
+	}
+	defer listenerFile.Close()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(executable, "agent", "-ttl", ttl.String())
+	cmd.Env = append(os.Environ(), agentChildEnv+"=1")
+	cmd.ExtraFiles = []*os.File{r, listenerFile}
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return 0, err
+	}
+	w.Write([]byte(password))
+	w.Close()
+
+	// The socket now belongs to the child.
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	return cmd.Process.Pid, nil
+}
+
+func runAgentChild(ttl time.Duration) int {
+	passwordFile := os.NewFile(3, "password")
+	password, err := ioutil.ReadAll(passwordFile)
+	passwordFile.Close()
+	if err != nil {
+		return 1
+	}
+	listenerFile := os.NewFile(4, "listener")
+	l, err := net.FileListener(listenerFile)
+	listenerFile.Close()
+	if err != nil {
+		return 1
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	if err := ctx.ReadKeyRing(); err != nil {
+		l.Close()
+		return 1
+	}
+	agent, err := store.NewAgent(ctx.PrivateRing, string(password))
+	if err != nil {
+		l.Close()
+		return 1
+	}
+	return serveAgent(agent, l, ttl)
+}
+
+// serveAgent runs agent until its ttl expires or the process is interrupted.
+func serveAgent(agent *store.Agent, l net.Listener, ttl time.Duration) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		agent.Stop()
+	}()
+
+	if err := agent.Serve(l, ttl); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
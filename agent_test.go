@@ -0,0 +1,125 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// captureStderr returns what fn writes to stderr.
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	f, err := ioutil.TempFile(t.TempDir(), "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	stderr := os.Stderr
+	os.Stderr = f
+	defer func() { os.Stderr = stderr }()
+	fn()
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// openTestProject returns a context of the project dir as the commands
+// open it, with its key rings read.
+func openTestProject(t *testing.T, dir string) *store.SecureContext {
+	t.Helper()
+	ctx := store.NewSecureContext(store.DefaultSecureRingPath, store.DefaultPublicRingPath, dir)
+	if err := ctx.ReadKeyRing(); err != nil {
+		t.Fatal(err)
+	}
+	return ctx
+}
+
+func TestUnlockUnsafeAgent(t *testing.T) {
+	dir := testProject(t)
+	prompts := 0
+	promptPassword = func() (string, error) {
+		prompts++
+		return "test", nil
+	}
+	// The directory of the socket is accessible by others.
+	socketDir := t.TempDir()
+	if err := os.Chmod(socketDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(store.AgentSocketEnv, filepath.Join(socketDir, "agent.sock"))
+
+	ctx := openTestProject(t, dir)
+	var err error
+	stderr := captureStderr(t, func() { err = unlock(ctx) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stderr, "warning: not using the agent: ") {
+		t.Errorf("got stderr %q, want a warning", stderr)
+	}
+	if prompts != 1 || ctx.Password != "test" || ctx.Agent != nil {
+		t.Errorf("asked for the password %d times, agent %v", prompts, ctx.Agent)
+	}
+}
+
+func TestUnlockAgentStopped(t *testing.T) {
+	dir := testProject(t)
+	prompts := 0
+	promptPassword = func() (string, error) {
+		prompts++
+		return "test", nil
+	}
+	socketPath := filepath.Join(t.TempDir(), "gosec", "agent.sock")
+	os.Setenv(store.AgentSocketEnv, socketPath)
+	l, err := store.ListenAgent(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent, err := store.NewAgent(openTestProject(t, "").PrivateRing, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- agent.Serve(l, time.Minute) }()
+
+	ctx := openTestProject(t, dir)
+	if err := unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if prompts != 0 || ctx.Agent == nil {
+		t.Fatalf("asked for the password %d times with an agent running", prompts)
+	}
+
+	agent.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	var plaintext []byte
+	stderr := captureStderr(t, func() { plaintext, err = ctx.ReadSecret(ctx.SecretPath("logins.txt")) })
+	if err != nil || !strings.Contains(string(plaintext), "hunter2") {
+		t.Fatalf("got %q, %v, want the plaintext", plaintext, err)
+	}
+	if prompts != 1 || !strings.HasPrefix(stderr, "warning: agent: ") {
+		t.Errorf("asked for the password %d times, stderr %q", prompts, stderr)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// auditCommand exits 1 when any secret does not match its access list.
+func auditCommand(args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	pf := newProjectFlags(fs)
+	filter := filterFlags(fs)
+	keepGoingPtr := keepGoingFlag(fs)
+	outputPtr := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s audit {-s DIR | -workspace DIR} [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := validOutput(*outputPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	failures := newFailureLog(*keepGoingPtr)
+	projects, err := pf.Projects(failures)
+	if err != nil {
+		if err == errNoRoot {
+			fs.Usage()
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	ctx.Filter = filter
+	ctx.Failures = failures
+	err = ctx.ReadKeyRing()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	mismatches := 0
+	printer := func(record store.AuditRecord) error {
+		mismatches++
+		_, err := fmt.Println(prefixed(record.SecretRecord, record.Secret+": "+record.Problems()))
+		return err
+	}
+	var rw *recordWriter
+	if *outputPtr != OutputText {
+		rw = newRecordWriter(os.Stdout, *outputPtr)
+		printer = func(record store.AuditRecord) error {
+			mismatches++
+			return rw.Write(record)
+		}
+	}
+
+	for _, project := range projects {
+		if err = ctx.ForProject(project).Audit(printer); err != nil {
+			break
+		}
+	}
+	if rw != nil {
+		if closeErr := rw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if mismatches > 0 {
+		return exitCode(ctx, 1)
+	}
+	return exitCode(ctx, 0)
+}
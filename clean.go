@@ -0,0 +1,64 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+func cleanCommand(args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Directory")
+	toPtr := fs.String("to", "", "Plaintext `DIR`, \""+store.TmpfsTarget+"\" for the memory backed default")
+	extPtr := fs.String("ext", "", "Comma separated `EXTENSIONS` of plaintext files, \"*\" for all")
+	followSymlinksPtr := fs.Bool("follow-symlinks", false, "Follow symlinks inside the plaintext directory")
+	keepGoingPtr := keepGoingFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s clean -s DIR [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *directoryRootPtr == "" {
+		fs.Usage()
+		fmt.Fprintln(os.Stderr, "Root directory must be specified")
+		return 2
+	}
+
+	ctx, err := OpenSecureContext(*directoryRootPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *keepGoingPtr {
+		ctx.Failures = &store.FailureLog{}
+	}
+	ctx.Extensions = store.ParseExtensions(*extPtr)
+	ctx.FollowSymlinks = *followSymlinksPtr
+	if err := ctx.SetPlaintextDir(*toPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if err := ctx.Clean(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return exitCode(ctx, 0)
+}
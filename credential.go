@@ -0,0 +1,79 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// gitCredentialCommand is a git credential helper, run by git with the
+// operation as its last argument and the credential attributes on stdin.
+// Unknown operations are ignored, as the protocol asks of helpers.
+func gitCredentialCommand(args []string) int {
+	fs := flag.NewFlagSet("git-credential", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Project `DIR` holding the credentials")
+	namePtr := fs.String("name", store.DefaultCredentialName, "Name the secret of a credential after `PATTERN`, expanding {protocol}, {host}, {path} and {username}")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s git-credential -s DIR [OPTION]... get|store|erase\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *directoryRootPtr == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	operation := fs.Arg(0)
+	if operation != "get" && operation != "store" && operation != "erase" {
+		return 0
+	}
+
+	c, err := store.ReadCredential(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	useTerminal()
+	ctx, err := OpenSecureContext(*directoryRootPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	switch operation {
+	case "get":
+		var found *store.Credential
+		found, err = ctx.FindCredential(*namePtr, c)
+		if err == nil && found != nil {
+			err = found.Encode(os.Stdout)
+		}
+	case "store":
+		err = requirePassword(ctx)
+		if err == nil {
+			err = ctx.StoreCredential(*namePtr, c)
+		}
+	case "erase":
+		err = ctx.EraseCredential(*namePtr, c)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// runGitCredential runs gosec git-credential operation on the project dir
+// as git would, with input on stdin, and returns its output.
+func runGitCredential(t *testing.T, dir, operation, input string) string {
+	t.Helper()
+	stdin, stdout := os.Stdin, os.Stdout
+	defer func() { os.Stdin, os.Stdout = stdin, stdout }()
+
+	in, err := ioutil.TempFile(t.TempDir(), "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.WriteString(input); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.TempFile(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	os.Stdin, os.Stdout = in, out
+
+	if code := gitCredentialCommand([]string{"-s", dir, operation}); code != 0 {
+		t.Fatalf("git-credential %v: exit %d", operation, code)
+	}
+	b, err := ioutil.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGitCredentialCommand(t *testing.T) {
+	dir := testProject(t)
+	const request = "protocol=https\nhost=git.example.com\n"
+
+	if got := runGitCredential(t, dir, "get", request); got != "" {
+		t.Errorf("get before store: %q", got)
+	}
+	runGitCredential(t, dir, "store", request+"username=alice\npassword=pw\n")
+	if got, want := runGitCredential(t, dir, "get", request), "username=alice\npassword=pw\n"; got != want {
+		t.Errorf("get: got %q, want %q", got, want)
+	}
+	if got := runGitCredential(t, dir, "get", request+"username=bob\n"); got != "" {
+		t.Errorf("get for another username: %q", got)
+	}
+
+	runGitCredential(t, dir, "erase", request+"username=alice\npassword=pw\n")
+	if got := runGitCredential(t, dir, "get", request); got != "" {
+		t.Errorf("get after erase: %q", got)
+	}
+	// Operations git may add later are ignored.
+	runGitCredential(t, dir, "capability", "")
+}
@@ -0,0 +1,108 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// forwardedSignals are passed on to the command run by exec.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+// execCommand runs a command with the entries of KEY=value secrets added to
+// its environment. It exits with the exit code of the command, 128 plus the
+// signal number if it was killed by a signal, or 127 if it could not be run.
+func execCommand(args []string) int {
+	var secrets stringList
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Directory")
+	fs.Var(&secrets, "f", "KEY=value `SECRET` to export; may be given more than once, later ones win")
+	prefixPtr := fs.String("prefix", "", "Prepend `PREFIX` to the variable names")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s exec -s DIR -f SECRET [OPTION]... -- COMMAND [ARG]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *directoryRootPtr == "" || len(secrets) == 0 || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, err := OpenSecureContext(*directoryRootPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	env := os.Environ()
+	for _, name := range secrets {
+		vars, err := ctx.ReadEnv(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", name, err)
+			return 2
+		}
+		for _, v := range vars {
+			env = setEnv(env, *prefixPtr+v.Name, v.Value)
+		}
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 127
+	}
+	go func() {
+		for sig := range signals {
+			cmd.Process.Signal(sig)
+		}
+	}()
+
+	err = cmd.Wait()
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			fmt.Fprintln(os.Stderr, err)
+			return 127
+		}
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// setEnv sets name to value in env, a list of NAME=value entries.
+func setEnv(env []string, name, value string) []string {
+	for i, entry := range env {
+		if strings.HasPrefix(entry, name+"=") {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
@@ -0,0 +1,228 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/rphillips/gosec/store"
+)
+
+var exportFormats = map[string]struct {
+	command     func(args []string) int
+	description string
+}{
+	"dir":   {exportDirCommand, "Directory of one file per key, for systemd or Docker"},
+	"env":   {exportEnvCommand("env", store.EncodeEnv), "KEY=value lines of a .env file"},
+	"k8s":   {exportK8sCommand, "Kubernetes v1 Secret manifest"},
+	"shell": {exportEnvCommand("shell", store.EncodeShell), "export KEY='value' lines for sh(1)"},
+}
+
+func exportUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s export FORMAT [OPTION]... SOURCE\n\nFormats:\n", os.Args[0])
+	names := make([]string, 0, len(exportFormats))
+	for name := range exportFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8v %v\n", name, exportFormats[name].description)
+	}
+}
+
+// exportCommand writes the entries of a directory of secrets or of a
+// structured secret, as read by store.ReadEntries, in the given format.
+func exportCommand(args []string) int {
+	if len(args) == 0 {
+		exportUsage()
+		return 2
+	}
+	format, ok := exportFormats[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown export format %q\n", args[0])
+		exportUsage()
+		return 2
+	}
+	return format.command(args[1:])
+}
+
+// exportK8sCommand implements "gosec export k8s".
+func exportK8sCommand(args []string) int {
+	var labels stringList
+	fs := flag.NewFlagSet("export k8s", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Project `DIR`, by default found from the leading directories of SOURCE")
+	namePtr := fs.String("name", "", "Name of the Secret, by default the base name of SOURCE")
+	namespacePtr := fs.String("namespace", "", "Namespace of the Secret")
+	fs.Var(&labels, "l", "Label the Secret with `KEY=VALUE`; may be given more than once")
+	outputPtr := fs.String("o", "", "Write to `FILE`, mode 0600, instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export k8s [OPTION]... SOURCE\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	secret := &store.K8sSecret{
+		Name:      *namePtr,
+		Namespace: *namespacePtr,
+		Labels:    map[string]string{},
+	}
+	for _, label := range labels {
+		i := strings.Index(label, "=")
+		if i <= 0 {
+			fmt.Fprintf(os.Stderr, "invalid label %q, expected KEY=VALUE\n", label)
+			return 2
+		}
+		secret.Labels[label[:i]] = label[i+1:]
+	}
+
+	dir, name, err := findSecret(*directoryRootPtr, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if secret.Name == "" {
+		secret.Name = path.Base(name)
+	}
+	if err := secret.Validate(); err != nil {
+		if nameErr, ok := err.(*store.K8sNameError); ok && nameErr.Field == "name" && *namePtr == "" {
+			fmt.Fprintf(os.Stderr, "%v: %v, set one with -name\n", fs.Arg(0), err)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 2
+	}
+	ctx, err := OpenSecureContext(dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if secret.Data, err = ctx.ReadEntries(name); err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	if err := writeExport(*outputPtr, secret.Encode); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// exportEnvCommand returns the command of an export format writing variables
+// with encode, their names derived from the keys by store.EnvName.
+func exportEnvCommand(format string, encode func(w io.Writer, entries []store.Entry) error) func(args []string) int {
+	return func(args []string) int {
+		fs := flag.NewFlagSet("export "+format, flag.ContinueOnError)
+		directoryRootPtr := fs.String("s", "", "Project `DIR`, by default found from the leading directories of SOURCE")
+		prefixPtr := fs.String("prefix", "", "Prepend `PREFIX` to the variable names")
+		outputPtr := fs.String("o", "", "Write to `FILE`, mode 0600, instead of stdout")
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "Usage: %s export %s [OPTION]... SOURCE\n", os.Args[0], format)
+			fs.PrintDefaults()
+		}
+		if err := fs.Parse(args); err != nil {
+			return 2
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+
+		entries, err := readExportEntries(*directoryRootPtr, fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		for i := range entries {
+			entries[i].Key = *prefixPtr + entries[i].Key
+		}
+		err = writeExport(*outputPtr, func(w io.Writer) error {
+			return encode(w, entries)
+		})
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+}
+
+// exportDirCommand implements "gosec export dir".
+func exportDirCommand(args []string) int {
+	fs := flag.NewFlagSet("export dir", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Project `DIR`, by default found from the leading directories of SOURCE")
+	outputPtr := fs.String("o", "", "Write the files, mode 0400, to `DIR`, created with mode 0700")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s export dir -o DIR [OPTION]... SOURCE\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *outputPtr == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	entries, err := readExportEntries(*directoryRootPtr, fs.Arg(0))
+	if err == nil {
+		err = store.WriteCredentialDir(*outputPtr, entries)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// readExportEntries opens the project of ref, as openSecret does, and reads
+// its entries.
+func readExportEntries(dir, ref string) ([]store.Entry, error) {
+	ctx, name, err := openSecret(dir, ref)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ctx.ReadEntries(name)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ref, err)
+	}
+	return entries, nil
+}
+
+// writeExport calls encode with the output file, written atomically with
+// mode 0600, or with stdout when outputPath is empty. An existing output file
+// readable by others is refused.
+func writeExport(outputPath string, encode func(w io.Writer) error) error {
+	if outputPath != "" {
+		if err := store.CheckOutputPath(outputPath); err != nil {
+			return err
+		}
+		return store.WriteFileAtomic(outputPath, store.PlaintextFileMode, encode)
+	}
+	if err := restrictStdout(); err != nil {
+		return err
+	}
+	return encode(os.Stdout)
+}
@@ -0,0 +1,42 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"testing"
+)
+
+func TestExportK8sName(t *testing.T) {
+	dir := testProject(t)
+	prompts := 0
+	promptPassword = func() (string, error) {
+		prompts++
+		return "test", nil
+	}
+
+	if got := exportK8sCommand([]string{"-s", dir, "-name", "Logins", "logins.txt"}); got != 2 {
+		t.Errorf("invalid -name: exit %d, want 2", got)
+	}
+	if got := exportK8sCommand([]string{"-s", dir, "-namespace", "prod.eu", "logins.txt"}); got != 2 {
+		t.Errorf("invalid -namespace: exit %d, want 2", got)
+	}
+	if prompts != 0 {
+		t.Errorf("asked for the password %d times for an invalid Secret", prompts)
+	}
+
+	// The default name, logins.txt, is valid.
+	if got := exportK8sCommand([]string{"-s", dir, "logins.txt"}); got != 0 {
+		t.Errorf("exit %d, want 0", got)
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// exitFailures is the exit code of a run that skipped files with -k.
+const exitFailures = 3
+
+// keepGoingFlag registers the -k flag on fs.
+func keepGoingFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("k", false, "Keep going after per-file errors and summarize them")
+}
+
+// newFailureLog returns the log of skipped files for -k, or nil without it.
+func newFailureLog(keepGoing bool) *store.FailureLog {
+	if !keepGoing {
+		return nil
+	}
+	return &store.FailureLog{}
+}
+
+// exitCode returns code, unless files were skipped in keep going mode, in
+// which case it prints the summary to stderr and returns exitFailures.
+func exitCode(ctx *store.SecureContext, code int) int {
+	if ctx.Failures == nil || len(ctx.Failures.Errors) == 0 {
+		return code
+	}
+	for _, fileErr := range ctx.Failures.Errors {
+		printConflictDiff(fileErr)
+	}
+	ctx.Failures.Summary(os.Stderr)
+	return exitFailures
+}
+
+// printConflictDiff writes the diff of a conflict found with -conflict=diff
+// to stdout.
+func printConflictDiff(err error) {
+	if fileErr, ok := err.(*store.FileError); ok {
+		err = fileErr.Err
+	}
+	if conflictErr, ok := err.(*store.ConflictError); ok {
+		os.Stdout.Write(conflictErr.Diff)
+	}
+}
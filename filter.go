@@ -0,0 +1,29 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// filterFlags registers the -p, -include and -exclude flags on fs.
+func filterFlags(fs *flag.FlagSet) *store.SecretFilter {
+	filter := &store.SecretFilter{}
+	fs.StringVar(&filter.Prefix, "p", "", "Only use secrets under `PATH`, which may be a glob")
+	fs.Var((*stringList)(&filter.Include), "include", "Only use secrets matching `GLOB`; may be given more than once")
+	fs.Var((*stringList)(&filter.Exclude), "exclude", "Skip secrets matching `GLOB`; may be given more than once")
+	return filter
+}
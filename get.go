@@ -0,0 +1,100 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// getCommand prints a secret or, given a field path, a single field of a
+// structured secret.
+func getCommand(args []string) int {
+	fs := flag.NewFlagSet("get", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Project `DIR`, by default found from the leading directories of SECRET")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s get [OPTION]... SECRET [FIELD]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 && fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, name, err := openSecret(*directoryRootPtr, fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if fs.NArg() == 1 {
+		r, err := ctx.Open(name)
+		if err == nil {
+			_, err = io.Copy(os.Stdout, r)
+			r.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", fs.Arg(0), err)
+			return 1
+		}
+		return 0
+	}
+
+	fields, err := ctx.ReadFields(name)
+	var field *store.Field
+	if err == nil {
+		field, err = fields.Lookup(fs.Arg(1))
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", fs.Arg(0), err)
+		return 1
+	}
+	if field.Fields != nil {
+		fmt.Print(field.Fields)
+	} else {
+		fmt.Println(field.Value)
+	}
+	return 0
+}
+
+// openSecret returns an unlocked context for the project of the secret ref
+// and the name of the secret in it.
+func openSecret(dir, ref string) (*store.SecureContext, string, error) {
+	dir, name, err := findSecret(dir, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	ctx, err := OpenSecureContext(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	return ctx, name, nil
+}
+
+// findSecret returns the project directory of the secret ref and the name of
+// the secret in it. Without a project directory, the project is found with
+// store.FindSecret.
+func findSecret(dir, ref string) (string, string, error) {
+	if dir != "" {
+		return dir, ref, nil
+	}
+	return store.FindSecret(ref)
+}
@@ -0,0 +1,134 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// useTerminal makes the password prompt read from the controlling terminal,
+// if there is one. Git runs drivers and hooks with their standard input
+// redirected.
+func useTerminal() {
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		os.Stdin = tty
+	}
+}
+
+// openDriverContext returns a context with unlocked keys for the git drivers,
+// which are not tied to a single project directory.
+func openDriverContext() (*store.SecureContext, error) {
+	useTerminal()
+	return OpenSecureContext("")
+}
+
+// gitDiffCommand is a git textconv driver: it writes the decrypted contents
+// of the secret at its only argument to stdout. Files that are not armored,
+// such as those already decrypted by the gosec filter, are written as is.
+func gitDiffCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s git-diff FILE\n", os.Args[0])
+		return 2
+	}
+
+	ciphertext, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !store.IsArmored(ciphertext) {
+		os.Stdout.Write(ciphertext)
+		return 0
+	}
+	ctx, err := openDriverContext()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	plaintext, err := ctx.DecryptBytes(ciphertext)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", args[0], err)
+		return 1
+	}
+	if _, err := os.Stdout.Write(plaintext); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// gitMergeCommand is a git merge driver: it merges the decrypted contents of
+// the base, current and other versions of a secret and encrypts the result
+// over the current version. It exits 1 when conflict markers were left in.
+func gitMergeCommand(args []string) int {
+	if len(args) != 3 && len(args) != 4 {
+		fmt.Fprintf(os.Stderr, "Usage: %s git-merge BASE CURRENT OTHER [PATH]\n", os.Args[0])
+		return 2
+	}
+	pathName := ""
+	if len(args) == 4 {
+		pathName = args[3]
+	}
+	name := pathName
+	if name == "" {
+		name = args[1]
+	}
+
+	ctx, err := openDriverContext()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var versions [3][]byte
+	for i, filePath := range args[:3] {
+		ciphertext, err := ioutil.ReadFile(filePath)
+		if err == nil {
+			versions[i], err = ctx.DecryptBytes(ciphertext)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filePath, err)
+			return 2
+		}
+	}
+
+	entityList, err := ctx.MergeRecipients(pathName, args[1])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if err := requirePassword(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	merged, conflicts := store.Merge3(versions[0], versions[1], versions[2])
+	err = store.WriteFileAtomic(args[1], 0644, func(w io.Writer) error {
+		return ctx.Encrypt(w, merged, entityList)
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if conflicts > 0 {
+		fmt.Fprintf(os.Stderr, "%v: %d conflict(s), decrypt it to resolve them\n", name, conflicts)
+		return 1
+	}
+	return 0
+}
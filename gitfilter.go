@@ -0,0 +1,62 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// gitFilterCommand runs the clean or smudge filter on stdin for the file
+// named by its second argument, git's %f.
+func gitFilterCommand(args []string) int {
+	if len(args) != 2 || (args[0] != "clean" && args[0] != "smudge") {
+		fmt.Fprintf(os.Stderr, "Usage: %s git-filter {clean | smudge} FILE\n", os.Args[0])
+		return 2
+	}
+
+	input, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	filter, err := store.NewGitFilter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	filter.Prompt = func() (string, error) {
+		useTerminal()
+		return promptPassword()
+	}
+
+	var output []byte
+	if args[0] == "clean" {
+		output, err = filter.Clean(args[1], input)
+	} else {
+		output, err = filter.Smudge(args[1], input)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v: %v\n", args[1], err)
+		return 1
+	}
+	if _, err := os.Stdout.Write(output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
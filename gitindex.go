@@ -0,0 +1,73 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// checkCommand exits 1 when the plaintext of any secret is tracked or staged
+// in git.
+func checkCommand(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	pf := newProjectFlags(fs)
+	toPtr := fs.String("to", "", "Plaintext `DIR`, \""+store.TmpfsTarget+"\" for the memory backed default")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s check {-s DIR | -workspace DIR} [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	projects, err := pf.Projects(nil)
+	if err != nil {
+		if err == errNoRoot {
+			fs.Usage()
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	found := 0
+	for _, project := range projects {
+		projectCtx := ctx.ForProject(project)
+		if err := projectCtx.SetPlaintextDir(*toPtr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		tracked, err := projectCtx.TrackedPlaintext()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, plainPath := range tracked {
+			found++
+			fmt.Printf("%v: plaintext is tracked by git\n", plainPath)
+		}
+	}
+	if found > 0 {
+		return 1
+	}
+	return 0
+}
@@ -14,36 +14,66 @@
 package main
 
 import (
-	"bufio"
-	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"os"
-	"os/user"
-	"path"
-	"path/filepath"
-	"regexp"
-	"strings"
-
 	"log"
+	"os"
+	"sort"
 
 	"github.com/bgentry/speakeasy"
-	"golang.org/x/crypto/openpgp"
-	"golang.org/x/crypto/openpgp/armor"
+	"github.com/rphillips/gosec/store"
 )
 
-var DefaultSecureRingPath = "~/.gnupg/secring.gpg"
-var DefaultPublicRingPath = "~/.gnupg/pubring.gpg"
 var DefaultPrompt = "password: "
 var version = "No version provided"
 
+// command is a gosec subcommand. Run returns the process exit code.
+type command struct {
+	Run   func(args []string) int
+	Short string
+}
+
+var commands = map[string]*command{
+	"agent":          {agentCommand, "Keep the private keys unlocked for other invocations"},
+	"audit":          {auditCommand, "Report secrets not encrypted to the access list"},
+	"check":          {checkCommand, "Fail if decrypted plaintext is tracked by git"},
+	"clean":          {cleanCommand, "Shred plaintext files that are safely encrypted"},
+	"exec":           {execCommand, "Run a command with KEY=value secrets in its environment"},
+	"export":         {exportCommand, "Export key/value secrets as env, shell, k8s or a directory"},
+	"get":            {getCommand, "Print a secret, or a field of a structured secret"},
+	"git-credential": {gitCredentialCommand, "Look up and store git credentials, as a credential helper"},
+	"git-diff":       {gitDiffCommand, "Decrypt a secret for git diff, as a textconv driver"},
+	"git-filter":     {gitFilterCommand, "Encrypt and decrypt files for git, as a clean and smudge filter"},
+	"git-merge":      {gitMergeCommand, "Merge a secret for git merge, as a merge driver"},
+	"grep":           {grepCommand, "grep(1) compatible search"},
+	"hook":           {hookCommand, "Install or run the git pre-commit checks"},
+	"init":           {initCommand, "Create a project and its .gitignore block"},
+	"inspect":        {inspectCommand, "Show recipients, signer and size of secrets"},
+	"ls":             {lsCommand, "List secrets"},
+	"render":         {renderCommand, "Render text/template templates referring to secrets"},
+	"resolve":        {resolveCommand, "Mark conflicts as resolved after merging the plaintext by hand"},
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd.Run(os.Args[2:]))
+		}
+	}
+
 	directoryRootPtr := flag.String("s", "", "Directory")
 	grepStringPtr := flag.String("g", "", "Regex String")
 	decryptFlagPtr := flag.Bool("d", false, "Decrypt")
 	encryptFlagPtr := flag.Bool("e", false, "Encrypt")
+	cleanFlagPtr := flag.Bool("clean", false, "Shred plaintext files after a verified -e")
 	versionFlagPtr := flag.Bool("v", false, "Display Version")
+	keepGoingFlagPtr := keepGoingFlag(flag.CommandLine)
+	extPtr := flag.String("ext", "", "Comma separated `EXTENSIONS` of plaintext files to encrypt, \"*\" for all")
+	conflictPtr := flag.String("conflict", store.ConflictRefuse, "On conflicts with -d or -e: refuse, diff or file")
+	followSymlinksPtr := flag.Bool("follow-symlinks", false, "Follow symlinks inside the plaintext directory with -e")
+	toPtr := flag.String("to", "", "Plaintext `DIR` for -d and -e, \""+store.TmpfsTarget+"\" for a memory backed default")
+	flag.Usage = Usage
 	flag.Parse()
 
 	if *versionFlagPtr {
@@ -57,347 +87,153 @@ func main() {
 		os.Exit(1)
 	}
 
-	ctx := NewSecureContext(
-		DefaultSecureRingPath,
-		DefaultPublicRingPath,
-		*directoryRootPtr,
-	)
-
-	err := ctx.ReadKeyRing()
+	ctx, err := OpenSecureContext(*directoryRootPtr)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
-
-	_, err = ctx.GetPassword()
-	if err != nil {
+	if *keepGoingFlagPtr {
+		ctx.Failures = &store.FailureLog{}
+	}
+	if err := ctx.SetPlaintextDir(*toPtr); err != nil {
 		log.Fatal(err)
 		return
 	}
-
-	if *decryptFlagPtr == true {
-		err = ctx.DecryptRoot()
-		if err != nil {
-			log.Fatal(err)
-			return
-		}
+	if err := store.ValidConflictMode(*conflictPtr); err != nil {
+		log.Fatal(err)
 		return
 	}
+	ctx.ConflictMode = *conflictPtr
+	ctx.Extensions = store.ParseExtensions(*extPtr)
+	ctx.FollowSymlinks = *followSymlinksPtr
+	ctx.Progress = func(status, name string) {
+		fmt.Printf("%-9v %v\n", status, name)
+	}
+	ctx.Warn = printWarning
 
-	if *encryptFlagPtr == true {
-		err = ctx.EncryptRoot()
-		if err != nil {
-			log.Fatal(err)
-			return
+	switch {
+	case *decryptFlagPtr:
+		err = ctx.DecryptRoot()
+	case *encryptFlagPtr:
+		err = requirePassword(ctx)
+		if err == nil {
+			err = ctx.EncryptRoot()
 		}
-		return
+		if err == nil && *cleanFlagPtr {
+			err = ctx.Clean()
+		}
+	default:
+		err = FindRegex(ctx, *grepStringPtr)
 	}
-
-	err = ctx.FindRegex(*grepStringPtr)
+	printConflictDiff(err)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
-}
-
-type SecureContext struct {
-	SecureRingPath string
-	PubRingPath    string
-	DirectoryRoot  string
-
-	PrivateRing openpgp.EntityList
-	PublicRing  openpgp.EntityList
-	Password    string
 
-	SearchRegex *regexp.Regexp
-}
-
-func NewSecureContext(secureRingPath, pubRingPath, directoryRoot string) *SecureContext {
-	return &SecureContext{
-		SecureRingPath: secureRingPath,
-		PubRingPath:    pubRingPath,
-		DirectoryRoot:  directoryRoot,
-	}
-}
-
-func (ctx *SecureContext) GetPassword() (string, error) {
-	var password string
-	var err error
-	if password, err = speakeasy.Ask(DefaultPrompt); err != nil {
-		return "", err
-	}
-	ctx.Password = password
-	return ctx.Password, nil
+	os.Exit(exitCode(ctx, 0))
 }
 
-func (ctx *SecureContext) ReadKeyRing() error {
-	secringPath, _ := expandPath(ctx.SecureRingPath)
-	privringFile, err := os.Open(secringPath)
-	if err != nil {
-		return err
-	}
-	defer privringFile.Close()
+// OpenSecureContext creates a context for directoryRoot using the default
+// key rings, reads them and unlocks them with unlock.
+func OpenSecureContext(directoryRoot string) (*store.SecureContext, error) {
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		directoryRoot,
+	)
 
-	ctx.PrivateRing, err = openpgp.ReadKeyRing(privringFile)
+	err := ctx.ReadKeyRing()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	pubringPath, _ := expandPath(ctx.PubRingPath)
-	pubringFile, err := os.Open(pubringPath)
+	err = unlock(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer pubringFile.Close()
-
-	ctx.PublicRing, err = openpgp.ReadKeyRing(pubringFile)
-	return err
+	return ctx, nil
 }
 
-func (ctx *SecureContext) FindRegex(regexStr string) error {
-	var regex *regexp.Regexp
-	var err error
-
-	if len(regexStr) > 0 {
-		regex, err = regexp.Compile(regexStr)
-		if err != nil {
-			return err
-		}
-	}
-
-	fileCallback := func(path string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			return nil
-		}
-		if filepath.Ext(fi.Name()) != ".gpg" {
-			return nil
-		}
-
-		md, err := ctx.DecryptFile(path)
-		if err != nil {
-			return err
-		}
-
-		if regex != nil {
-			newWriteLine := func(path string) func(uint64, string) {
-				first := true
-				return func(lineNumber uint64, line string) {
-					if first {
-						fmt.Println(path)
-						first = false
-					}
-					fmt.Printf("%v:%v\n", lineNumber, line)
-				}
-			}
-
-			foundMatch := false
-			lineNumber := uint64(0)
-			writeLine := newWriteLine(path)
-			scanner := bufio.NewScanner(md.UnverifiedBody)
-			for scanner.Scan() {
-				lineNumber++
-				line := scanner.Text()
-				if regex.Match([]byte(line)) {
-					foundMatch = true
-					writeLine(lineNumber, line)
-				}
-			}
-
-			if foundMatch {
-				fmt.Println()
-			}
-		} else {
-			io.Copy(os.Stdout, md.UnverifiedBody)
-		}
-
+// unlock lets ctx decrypt secrets: through the agent when one is running,
+// otherwise by prompting for the password. An agent socket that is not safe
+// to use is warned about, and an agent that fails later is given up for the
+// password too.
+func unlock(ctx *store.SecureContext) error {
+	ctx.Warn = printWarning
+	agent, err := store.DialAgent(store.AgentSocketPath())
+	if err == nil {
+		ctx.Agent = agent
+		ctx.Prompt = promptPassword
 		return nil
 	}
-
-	filesPath := path.Join(ctx.DirectoryRoot, "files")
-	return filepath.Walk(filesPath, fileCallback)
+	if _, ok := err.(*store.UnsafeAgentError); ok {
+		printWarning(err)
+	}
+	_, err = GetPassword(ctx)
+	return err
 }
 
-func GetKeyByEmail(keyRing openpgp.EntityList, emailAddress string) *openpgp.Entity {
-	for _, entity := range keyRing {
-		for _, ident := range entity.Identities {
-			if ident.UserId.Email == emailAddress {
-				if entity.PrimaryKey.PublicKey == nil {
-					return nil
-				}
-				return entity
-			}
-		}
-	}
-	return nil
+func printWarning(err error) {
+	fmt.Fprintf(os.Stderr, "warning: %v\n", err)
 }
 
-func (ctx *SecureContext) ReadAccessList() (openpgp.EntityList, error) {
-	fp, err := os.Open(path.Join(ctx.DirectoryRoot, "access-list.conf"))
-	if err != nil {
-		return nil, err
+// requirePassword prompts for the password if ctx was unlocked through the
+// agent, which cannot sign.
+func requirePassword(ctx *store.SecureContext) error {
+	if ctx.Password != "" {
+		return nil
 	}
-	defer fp.Close()
+	_, err := GetPassword(ctx)
+	return err
+}
 
-	commentRegex, err := regexp.Compile("^#")
+// GetPassword prompts for the password of the secret keyring and sets it on
+// ctx.
+func GetPassword(ctx *store.SecureContext) (string, error) {
+	password, err := promptPassword()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
+	ctx.Password = password
+	return ctx.Password, nil
+}
 
-	entityList := openpgp.EntityList{}
-
-	scanner := bufio.NewScanner(fp)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if commentRegex.Match([]byte(line)) {
-			continue
-		}
-		entity := GetKeyByEmail(ctx.PublicRing, strings.TrimSpace(line))
-		if entity == nil {
-			return nil, errors.New(line + " not in keyring")
-		}
-		entityList = append(entityList, entity)
-	}
-	return entityList, nil
+// promptPassword asks for the password on the terminal. Tests replace it.
+var promptPassword = func() (string, error) {
+	return speakeasy.FAsk(os.Stderr, DefaultPrompt)
 }
 
-func (ctx *SecureContext) EncryptRoot() error {
-	entityList, err := ctx.ReadAccessList()
-	if err != nil {
+func FindRegex(ctx *store.SecureContext, regexStr string) error {
+	if len(regexStr) > 0 {
+		opts := &store.GrepOptions{Patterns: []string{regexStr}}
+		_, err := ctx.Grep(opts, grepTextPrinter(os.Stdout, opts))
 		return err
 	}
 
-	fileCallback := func(filePath string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			return nil
-		}
-		if filepath.Ext(fi.Name()) != ".txt" {
-			return nil
-		}
-
-		fp, err := os.Open(filePath)
-		if err != nil {
-			return err
-		}
-		defer fp.Close()
-
-		filePath = strings.Replace(filePath, ".txt", ".gpg", 1)
-		destRootPath := path.Join(ctx.DirectoryRoot, "files")
-		destPath := path.Join(destRootPath, filepath.Base(filePath))
-
-		_, err = os.Stat(destRootPath)
-		if err != nil {
-			err = os.Mkdir(destRootPath, 0700)
-			if err != nil {
-				return err
-			}
-		}
-
-		destFp, err := os.Create(destPath)
-		if err != nil {
-			return err
-		}
-		defer destFp.Close()
-
-		w, err := armor.Encode(destFp, "PGP MESSAGE", nil)
-		if err != nil {
-			return err
-		}
-		defer w.Close()
-
-		cleartext, err := openpgp.Encrypt(w, entityList, nil, nil, nil)
-		if err != nil {
-			return err
-		}
-		io.Copy(cleartext, fp)
-		cleartext.Close()
-
-		return nil
-	}
-
-	return filepath.Walk(ctx.DirectoryRoot, fileCallback)
-}
-
-func (ctx *SecureContext) DecryptRoot() error {
-	fileCallback := func(filePath string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if fi.IsDir() {
-			return nil
-		}
-		if filepath.Ext(fi.Name()) != ".gpg" {
-			return nil
-		}
+	return ctx.WalkSecrets(func(filePath string) error {
 		md, err := ctx.DecryptFile(filePath)
 		if err != nil {
 			return err
 		}
 
-		baseName := filepath.Base(filePath)
-		newBase := strings.Replace(baseName, ".gpg", ".txt", 1)
-		newFilePath := path.Join(ctx.DirectoryRoot, newBase)
-
-		fp, err := os.Create(newFilePath)
-		if err != nil {
-			return err
-		}
-		defer fp.Close()
-		_, err = io.Copy(fp, md.UnverifiedBody)
-		return err
-	}
-
-	filesPath := path.Join(ctx.DirectoryRoot, "files")
-	return filepath.Walk(filesPath, fileCallback)
-}
-
-func (ctx *SecureContext) DecryptFile(filePath string) (*openpgp.MessageDetails, error) {
-	secfile, err := os.Open(filePath)
-	if err != nil {
-		return nil, err
-	}
-
-	block, err := armor.Decode(secfile)
-	if err != nil {
-		return nil, err
-	}
-
-	promptCallback := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
-		for _, k := range keys {
-			err := k.PrivateKey.Decrypt([]byte(ctx.Password))
-			if err != nil {
-				return nil, err
-			}
-			return nil, nil
-		}
-		return nil, errors.New("invalid password or no private key")
-	}
-
-	return openpgp.ReadMessage(block.Body, ctx.PrivateRing, promptCallback, nil)
-}
-
-func expandPath(p string) (string, error) {
-	if path.IsAbs(p) {
-		return p, nil
-	}
-	if p[:2] == "~/" {
-		usr, err := user.Current()
-		if err != nil {
-			return "", err
-		}
-		p = strings.Replace(p, "~", usr.HomeDir, 1)
-	}
-	return p, nil
+		io.Copy(os.Stdout, md.UnverifiedBody)
+		return ctx.CheckSignature(md)
+	})
 }
 
 var Usage = func() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nCommands (see %s COMMAND -h):\n", os.Args[0])
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, commands[name].Short)
+	}
 }
 
 var Version = func() {
@@ -0,0 +1,216 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// grepTextPrinter prints results grouped under a heading with the secret's
+// path, matching lines as "n:line" and context lines as "n-line", with "--"
+// between non-adjacent groups.
+func grepTextPrinter(w io.Writer, opts *store.GrepOptions) func(*store.GrepResult) error {
+	separate := opts.BeforeContext > 0 || opts.AfterContext > 0
+	return func(result *store.GrepResult) error {
+		switch {
+		case opts.FilesWithMatches:
+			if result.Count > 0 {
+				fmt.Fprintln(w, prefixed(result.Record, result.Record.Path))
+			}
+			return nil
+		case opts.Count:
+			fmt.Fprintf(w, "%v:%v\n", prefixed(result.Record, result.Record.Path), result.Count)
+			return nil
+		case len(result.Lines) == 0:
+			return nil
+		}
+
+		fmt.Fprintln(w, prefixed(result.Record, result.Record.Path))
+		for i, line := range result.Lines {
+			if separate && i > 0 && line.Number > result.Lines[i-1].Number+1 {
+				fmt.Fprintln(w, "--")
+			}
+			sep := ":"
+			if line.Context {
+				sep = "-"
+			}
+			fmt.Fprintf(w, "%v%s%v\n", line.Number, sep, line.Text)
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+}
+
+// grepRecordPrinter prints a MatchRecord per line, a CountRecord per secret
+// with -c or a SecretRecord per matching secret with -l.
+func grepRecordPrinter(rw *recordWriter, opts *store.GrepOptions) func(*store.GrepResult) error {
+	return func(result *store.GrepResult) error {
+		switch {
+		case opts.FilesWithMatches:
+			if result.Count > 0 {
+				return rw.Write(result.Record)
+			}
+			return nil
+		case opts.Count:
+			return rw.Write(store.CountRecord{SecretRecord: result.Record, Count: result.Count})
+		}
+
+		for _, line := range result.Lines {
+			err := rw.Write(store.MatchRecord{
+				SecretRecord: result.Record,
+				Line:         line.Number,
+				Text:         line.Text,
+				Matches:      line.Matches,
+				Context:      line.Context,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// stringList is a flag.Value collecting every occurrence of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// grepCommand implements "gosec grep". Like grep(1) it exits 0 when a line
+// was selected, 1 when none was and 2 on error.
+func grepCommand(args []string) int {
+	opts := &store.GrepOptions{}
+	var patterns stringList
+	var context int
+
+	fs := flag.NewFlagSet("grep", flag.ContinueOnError)
+	pf := newProjectFlags(fs)
+	filter := filterFlags(fs)
+	keepGoingPtr := keepGoingFlag(fs)
+	fs.Var(&patterns, "e", "Use `PATTERN` for matching; may be given more than once")
+	fs.BoolVar(&opts.IgnoreCase, "i", false, "Ignore case distinctions")
+	fs.BoolVar(&opts.InvertMatch, "v", false, "Select non-matching lines")
+	fs.BoolVar(&opts.Count, "c", false, "Print only a count of selected lines per secret")
+	fs.BoolVar(&opts.FilesWithMatches, "l", false, "Print only the names of secrets with selected lines")
+	fs.BoolVar(&opts.WordRegexp, "w", false, "Match only whole words")
+	fs.BoolVar(&opts.FixedStrings, "F", false, "Interpret patterns as fixed strings")
+	fs.IntVar(&opts.AfterContext, "A", 0, "Print `NUM` lines of trailing context")
+	fs.IntVar(&opts.BeforeContext, "B", 0, "Print `NUM` lines of leading context")
+	fs.IntVar(&context, "C", 0, "Print `NUM` lines of context")
+	outputPtr := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s grep {-s DIR | -workspace DIR} [OPTION]... PATTERN\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Secrets are selected with -s, -workspace and the filter flags, so
+	// there is nothing for arguments after the pattern to name.
+	opts.Patterns = patterns
+	if len(opts.Patterns) == 0 {
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 2
+		}
+		opts.Patterns = []string{fs.Arg(0)}
+	} else if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+	if _, err := opts.Compile(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := validOutput(*outputPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if context > 0 {
+		if opts.AfterContext == 0 {
+			opts.AfterContext = context
+		}
+		if opts.BeforeContext == 0 {
+			opts.BeforeContext = context
+		}
+	}
+
+	failures := newFailureLog(*keepGoingPtr)
+	projects, err := pf.Projects(failures)
+	if err != nil {
+		if err == errNoRoot {
+			fs.Usage()
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx, err := OpenSecureContext("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	ctx.Filter = filter
+	ctx.Failures = failures
+
+	var printer func(*store.GrepResult) error
+	var rw *recordWriter
+	if *outputPtr == OutputText {
+		printer = grepTextPrinter(os.Stdout, opts)
+	} else {
+		rw = newRecordWriter(os.Stdout, *outputPtr)
+		printer = grepRecordPrinter(rw, opts)
+	}
+
+	found := false
+	for _, project := range projects {
+		var projectFound bool
+		projectFound, err = ctx.ForProject(project).Grep(opts, printer)
+		found = found || projectFound
+		if err != nil {
+			break
+		}
+	}
+	if rw != nil {
+		if closeErr := rw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !found {
+		return exitCode(ctx, 1)
+	}
+	return exitCode(ctx, 0)
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// testProject creates a project holding a logins secret, encrypted with the
+// key rings of store/testdata, and makes the commands use those key rings,
+// their password and a state key of the test, without an agent, until the
+// test ends. Stdout is discarded.
+func testProject(t *testing.T) string {
+	t.Helper()
+	secureRing, publicRing, stateKey := store.DefaultSecureRingPath, store.DefaultPublicRingPath, store.DefaultStateKeyPath
+	prompt, stdout := promptPassword, os.Stdout
+	socket, hadSocket := os.LookupEnv(store.AgentSocketEnv)
+	t.Cleanup(func() {
+		store.DefaultSecureRingPath, store.DefaultPublicRingPath, store.DefaultStateKeyPath = secureRing, publicRing, stateKey
+		promptPassword, os.Stdout = prompt, stdout
+		if hadSocket {
+			os.Setenv(store.AgentSocketEnv, socket)
+		} else {
+			os.Unsetenv(store.AgentSocketEnv)
+		}
+	})
+
+	store.DefaultSecureRingPath = "store/testdata/secring.gpg"
+	store.DefaultPublicRingPath = "store/testdata/pubring.gpg"
+	store.DefaultStateKeyPath = filepath.Join(t.TempDir(), "state.key")
+	promptPassword = func() (string, error) {
+		return "test", nil
+	}
+	// No agent listens there, and its directory does not exist either, so that
+	// the commands do not warn about it.
+	os.Setenv(store.AgentSocketEnv, filepath.Join(t.TempDir(), "gosec", "agent.sock"))
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { devNull.Close() })
+	os.Stdout = devNull
+
+	dir := t.TempDir()
+	files := map[string]string{
+		store.AccessListFileName: "alice@example.com\n",
+		"logins.txt":             "user: alice\npassword: hunter2\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := store.NewSecureContext(store.DefaultSecureRingPath, store.DefaultPublicRingPath, dir)
+	ctx.Password = "test"
+	if err := ctx.ReadKeyRing(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.EncryptRoot(); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestGrepCommandExitCode(t *testing.T) {
+	dir := testProject(t)
+	for _, test := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"-s", dir, "hunter"}, 0},
+		{[]string{"-s", dir, "-c", "nothing"}, 1},
+		{[]string{"-s", dir, "-e", "nothing", "-e", "alice"}, 0},
+		{[]string{"-s", dir, "("}, 2},
+		{[]string{"-s", dir, "hunter", "extra"}, 2},
+		{[]string{"-s", dir, "-e", "hunter", "extra"}, 2},
+		{[]string{"hunter"}, 2},
+	} {
+		if got := grepCommand(test.args); got != test.want {
+			t.Errorf("grep %q: exit %d, want %d", test.args, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// hookMarker identifies pre-commit hooks installed by gosec, which may be
+// replaced without -f.
+const hookMarker = "# Installed by gosec hook install."
+
+// hookScript runs gosec hook run. Git does not give hooks a terminal, so the
+// password prompt reads from /dev/tty when there is one.
+const hookScript = `#!/bin/sh
+` + hookMarker + `
+if (exec < /dev/tty) 2>/dev/null; then
+	exec < /dev/tty
+fi
+exec %v hook run
+`
+
+// HooksDir returns the hooks directory of the repository whose git directory
+// is gitDir. Work trees share the hooks of their main repository.
+func HooksDir(gitDir string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if os.IsNotExist(err) {
+		return filepath.Join(gitDir, "hooks"), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	commonDir := strings.TrimSpace(string(b))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+	return filepath.Join(commonDir, "hooks"), nil
+}
+
+// InstallHook writes a pre-commit hook running executable to the repository
+// whose git directory is gitDir. A pre-commit hook not installed by gosec is
+// only replaced if force is set.
+func InstallHook(gitDir, executable string, force bool) (string, error) {
+	hooksDir, err := HooksDir(gitDir)
+	if err != nil {
+		return "", err
+	}
+	hookPath := filepath.Join(hooksDir, "pre-commit")
+
+	existing, err := ioutil.ReadFile(hookPath)
+	if err == nil && !force && !bytes.Contains(existing, []byte(hookMarker)) {
+		return "", fmt.Errorf("%v already exists, use -f to replace it", hookPath)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
+		return "", err
+	}
+	quoted := "'" + strings.Replace(executable, "'", `'\''`, -1) + "'"
+	return hookPath, store.WriteFileAtomic(hookPath, 0755, func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, hookScript, quoted)
+		return err
+	})
+}
+
+func hookCommand(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			return hookInstallCommand(args[1:])
+		case "run":
+			return hookRunCommand(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s hook {install | run} [OPTION]...\n", os.Args[0])
+	return 2
+}
+
+func hookInstallCommand(args []string) int {
+	fs := flag.NewFlagSet("hook install", flag.ContinueOnError)
+	forcePtr := fs.Bool("f", false, "Replace an existing pre-commit hook")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s hook install [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	_, gitDir, err := store.FindGitDir(".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	hookPath, err := InstallHook(gitDir, executable, *forcePtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Printf("installed %v\n", hookPath)
+	return 0
+}
+
+// hookRunCommand exits 1 when the staged files fail the checks of StagedCheck.
+// The password is only asked for when ciphertext is staged.
+func hookRunCommand(args []string) int {
+	fs := flag.NewFlagSet("hook run", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s hook run\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	workTree, _, err := store.FindGitDir(".")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	staged, err := store.StagedFiles(workTree)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var projects []string
+	projectFiles := map[string][]string{}
+	needPassword := false
+	for _, rel := range staged {
+		dir, ok := store.ProjectOf(workTree, rel)
+		if !ok {
+			continue
+		}
+		if _, ok := projectFiles[dir]; !ok {
+			projects = append(projects, dir)
+		}
+		projectFiles[dir] = append(projectFiles[dir], rel)
+		needPassword = needPassword || path.Ext(rel) == ".gpg"
+	}
+	if len(projects) == 0 {
+		return 0
+	}
+
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	if err := ctx.ReadKeyRing(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if needPassword {
+		if err := unlock(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	problems := 0
+	for _, dir := range projects {
+		projectCtx := ctx.ForProject(store.Project{Dir: filepath.Join(workTree, filepath.FromSlash(dir))})
+		check, err := projectCtx.NewStagedCheck(workTree)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", dir, err)
+			return 2
+		}
+		check.Report = func(filePath, problem string) {
+			problems++
+			fmt.Fprintf(os.Stderr, "%v: %v\n", filePath, problem)
+		}
+		if err := check.Run(projectFiles[dir]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", dir, err)
+			return 2
+		}
+	}
+	if problems > 0 {
+		fmt.Fprintf(os.Stderr, "gosec: commit rejected, %d problem(s)\n", problems)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,180 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rphillips/gosec/store"
+)
+
+func lsCommand(args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ContinueOnError)
+	pf := newProjectFlags(fs)
+	filter := filterFlags(fs)
+	keepGoingPtr := keepGoingFlag(fs)
+	outputPtr := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s ls {-s DIR | -workspace DIR} [OPTION]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := validOutput(*outputPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	failures := newFailureLog(*keepGoingPtr)
+	projects, err := pf.Projects(failures)
+	if err != nil {
+		if err == errNoRoot {
+			fs.Usage()
+		}
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := store.NewSecureContext(
+		store.DefaultSecureRingPath,
+		store.DefaultPublicRingPath,
+		"",
+	)
+	ctx.Filter = filter
+	ctx.Failures = failures
+	err = ctx.ReadKeyRing()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printer := func(record store.SecretRecord) error {
+		_, err := fmt.Println(prefixed(record, record.Secret))
+		return err
+	}
+	var rw *recordWriter
+	if *outputPtr != OutputText {
+		rw = newRecordWriter(os.Stdout, *outputPtr)
+		printer = func(record store.SecretRecord) error {
+			return rw.Write(record)
+		}
+	}
+
+	for _, project := range projects {
+		if err = ctx.ForProject(project).List(printer); err != nil {
+			break
+		}
+	}
+	if rw != nil {
+		if closeErr := rw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return exitCode(ctx, 0)
+}
+
+func inspectCommand(args []string) int {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Directory")
+	filter := filterFlags(fs)
+	keepGoingPtr := keepGoingFlag(fs)
+	outputPtr := outputFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s inspect -s DIR [OPTION]... [SECRET]...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := validOutput(*outputPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := filter.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *directoryRootPtr == "" {
+		fs.Usage()
+		fmt.Fprintln(os.Stderr, "Root directory must be specified")
+		return 2
+	}
+
+	ctx, err := OpenSecureContext(*directoryRootPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx.Filter = filter
+	if *keepGoingPtr {
+		ctx.Failures = &store.FailureLog{}
+	}
+
+	var rw *recordWriter
+	if *outputPtr != OutputText {
+		rw = newRecordWriter(os.Stdout, *outputPtr)
+	}
+	inspect := func(filePath string) error {
+		record, err := ctx.Inspect(filePath)
+		if err != nil {
+			return err
+		}
+		if rw != nil {
+			return rw.Write(record)
+		}
+
+		signer := record.Signer
+		if signer == "" {
+			signer = "none"
+		}
+		fmt.Println(record.Secret)
+		fmt.Printf("  path:       %v\n", record.Path)
+		fmt.Printf("  recipients: %v\n", strings.Join(record.Recipients, ", "))
+		fmt.Printf("  signer:     %v\n", signer)
+		fmt.Printf("  size:       %v\n", record.Size)
+		return nil
+	}
+
+	if fs.NArg() == 0 {
+		err = ctx.WalkSecrets(inspect)
+	} else {
+		for _, name := range fs.Args() {
+			if err = inspect(ctx.SecretPath(name)); err != nil {
+				break
+			}
+		}
+	}
+	if rw != nil {
+		if closeErr := rw.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return exitCode(ctx, 0)
+}
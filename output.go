@@ -0,0 +1,88 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// Output formats accepted by --output.
+const (
+	OutputText   = "text"
+	OutputJSON   = "json"
+	OutputNDJSON = "ndjson"
+)
+
+// recordWriter writes records as a JSON array or as newline delimited JSON.
+type recordWriter struct {
+	w       io.Writer
+	format  string
+	written int
+}
+
+func newRecordWriter(w io.Writer, format string) *recordWriter {
+	return &recordWriter{w: w, format: format}
+}
+
+func (rw *recordWriter) Write(record interface{}) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if rw.format == OutputJSON {
+		prefix = ",\n"
+		if rw.written == 0 {
+			prefix = "[\n"
+		}
+	}
+	rw.written++
+
+	_, err = fmt.Fprintf(rw.w, "%s%s", prefix, b)
+	if err == nil && rw.format == OutputNDJSON {
+		_, err = fmt.Fprintln(rw.w)
+	}
+	return err
+}
+
+// Close terminates the JSON array. It is a no-op for NDJSON.
+func (rw *recordWriter) Close() error {
+	if rw.format != OutputJSON {
+		return nil
+	}
+	var err error
+	if rw.written == 0 {
+		_, err = fmt.Fprintln(rw.w, "[]")
+	} else {
+		_, err = fmt.Fprintln(rw.w, "\n]")
+	}
+	return err
+}
+
+// outputFlag registers the --output flag on fs.
+func outputFlag(fs *flag.FlagSet) *string {
+	return fs.String("output", OutputText, "Output `FORMAT`: text, json or ndjson")
+}
+
+func validOutput(format string) error {
+	switch format {
+	case OutputText, OutputJSON, OutputNDJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q", format)
+}
@@ -0,0 +1,92 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// renderCommand executes text/template templates with the secret function
+// of store.SecretCache. Nothing is written unless every template renders.
+func renderCommand(args []string) int {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	outputPtr := fs.String("o", "", "Write to `FILE`, mode 0600, instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s render [OPTION]... TEMPLATE...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, err := OpenSecureContext("")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cache := store.NewSecretCache(ctx)
+
+	var rendered bytes.Buffer
+	for _, templatePath := range fs.Args() {
+		tmpl, err := template.New(filepath.Base(templatePath)).
+			Funcs(cache.FuncMap()).
+			ParseFiles(templatePath)
+		if err == nil {
+			err = tmpl.Execute(&rendered, nil)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	if *outputPtr != "" {
+		err = store.WriteFileAtomic(*outputPtr, store.PlaintextFileMode, func(w io.Writer) error {
+			_, err := w.Write(rendered.Bytes())
+			return err
+		})
+	} else {
+		err = restrictStdout()
+		if err == nil {
+			_, err = os.Stdout.Write(rendered.Bytes())
+		}
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// restrictStdout makes stdout mode 0600 when it was redirected to a regular
+// file, so that rendered secrets are not readable by others.
+func restrictStdout() error {
+	fi, err := os.Stdout.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	return os.Stdout.Chmod(store.PlaintextFileMode)
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/rphillips/gosec/store"
+)
+
+// resolveCommand marks conflicts as resolved after the plaintext was merged
+// by hand, so that the next -e encrypts it.
+func resolveCommand(args []string) int {
+	fs := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	directoryRootPtr := fs.String("s", "", "Directory")
+	toPtr := fs.String("to", "", "Plaintext `DIR`, \""+store.TmpfsTarget+"\" for the memory backed default")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s resolve -s DIR [OPTION]... SECRET...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *directoryRootPtr == "" || fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	ctx, err := OpenSecureContext(*directoryRootPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := ctx.SetPlaintextDir(*toPtr); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	ctx.Progress = func(status, name string) {
+		fmt.Printf("%-9v %v\n", status, name)
+	}
+
+	if err := ctx.Resolve(fs.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,462 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// AgentSocketEnv overrides the path of the agent socket.
+const AgentSocketEnv = "GOSEC_AGENT_SOCK"
+
+// KeyAgent decrypts session keys with private keys held elsewhere, so that
+// secrets can be decrypted without the password.
+type KeyAgent interface {
+	// DecryptKey sets the CipherFunc and Key of ek, as ek.Decrypt does.
+	DecryptKey(ek *packet.EncryptedKey) error
+}
+
+// AgentSocketPath returns the per-user path of the agent socket: the value
+// of $GOSEC_AGENT_SOCK or agent.sock in a private directory of
+// $XDG_RUNTIME_DIR, or of the temporary directory.
+func AgentSocketPath() string {
+	if socketPath := os.Getenv(AgentSocketEnv); socketPath != "" {
+		return socketPath
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "gosec", "agent.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gosec-%d", os.Getuid()), "agent.sock")
+}
+
+// agentRequest and agentResponse are exchanged, as JSON, once per
+// connection to the agent.
+type agentRequest struct {
+	Op     string `json:"op"`
+	Packet []byte `json:"packet,omitempty"`
+}
+
+type agentResponse struct {
+	CipherFunc packet.CipherFunction `json:"cipher,omitempty"`
+	Key        []byte                `json:"key,omitempty"`
+	Error      string                `json:"error,omitempty"`
+}
+
+const (
+	agentPing    = "ping"
+	agentDecrypt = "decrypt"
+)
+
+// agentTimeout bounds a request to the agent, from connecting to the last
+// byte of the response, so that a stuck peer is dropped.
+const agentTimeout = 10 * time.Second
+
+// Agent holds unlocked private keys and decrypts session keys with them for
+// the processes of the same user.
+type Agent struct {
+	mu       sync.Mutex
+	keys     openpgp.EntityList
+	listener net.Listener
+	stopped  bool
+}
+
+// NewAgent decrypts the private keys of privateRing with password.
+func NewAgent(privateRing openpgp.EntityList, password string) (*Agent, error) {
+	var keys openpgp.EntityList
+	for _, entity := range privateRing {
+		if entity.PrivateKey == nil {
+			continue
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(password)); err != nil {
+			return nil, errNoPrivateKey
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil {
+				if err := subkey.PrivateKey.Decrypt([]byte(password)); err != nil {
+					return nil, errNoPrivateKey
+				}
+			}
+		}
+		keys = append(keys, entity)
+	}
+	if len(keys) == 0 {
+		return nil, errNoPrivateKey
+	}
+	return &Agent{keys: keys}, nil
+}
+
+// UnsafeAgentError is returned for an agent socket, or its directory, that
+// another user could have created to stand in for the agent.
+type UnsafeAgentError struct {
+	Path   string
+	Reason string
+}
+
+func (e *UnsafeAgentError) Error() string {
+	return fmt.Sprintf("not using the agent: %v: %v", e.Path, e.Reason)
+}
+
+// AgentError is a failure to reach the agent or to get an answer from it, as
+// opposed to the agent having no key for a secret.
+type AgentError struct {
+	Err error
+}
+
+func (e *AgentError) Error() string {
+	return "agent: " + e.Err.Error()
+}
+
+// checkAgentDir returns an *UnsafeAgentError unless dir is a directory of
+// the current user that group and others cannot access.
+func checkAgentDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if reason := ownerProblem(fi, os.ModeDir, "directory"); reason != "" {
+		return &UnsafeAgentError{dir, reason}
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return &UnsafeAgentError{dir, fmt.Sprintf("it is accessible by group or others (mode %#o)", fi.Mode().Perm())}
+	}
+	return nil
+}
+
+// checkAgentSocket returns an *UnsafeAgentError unless socketPath is a
+// socket of the current user in a directory checked by checkAgentDir.
+func checkAgentSocket(socketPath string) error {
+	if err := checkAgentDir(filepath.Dir(socketPath)); err != nil {
+		return err
+	}
+	fi, err := os.Lstat(socketPath)
+	if err != nil {
+		return err
+	}
+	if reason := ownerProblem(fi, os.ModeSocket, "socket"); reason != "" {
+		return &UnsafeAgentError{socketPath, reason}
+	}
+	return nil
+}
+
+// ListenAgent listens on the unix socket at socketPath, creating its
+// directory, private to the user, if needed. An existing directory is left
+// as it is and must pass checkAgentDir. A socket left behind by an agent that
+// is no longer running is replaced.
+func ListenAgent(socketPath string) (net.Listener, error) {
+	dir := filepath.Dir(socketPath)
+	if _, err := os.Lstat(dir); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(dir), 0700); err != nil {
+			return nil, err
+		}
+		// Mkdir fails, rather than taking it over, if someone else created
+		// the directory meanwhile.
+		if err := os.Mkdir(dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(dir, 0700); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	if err := checkAgentDir(dir); err != nil {
+		return nil, err
+	}
+	if _, err := DialAgent(socketPath); err == nil {
+		return nil, fmt.Errorf("an agent is already listening on %v", socketPath)
+	}
+	os.Remove(socketPath)
+
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Serve answers the connections accepted on l until ttl has passed or Stop
+// is called, even before Serve. It returns nil once stopped.
+func (a *Agent) Serve(l net.Listener, ttl time.Duration) error {
+	a.mu.Lock()
+	if a.stopped {
+		a.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	a.listener = l
+	a.mu.Unlock()
+	expired := time.AfterFunc(ttl, a.Stop)
+	defer expired.Stop()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			a.mu.Lock()
+			defer a.mu.Unlock()
+			if a.stopped {
+				return nil
+			}
+			return err
+		}
+		go a.serveConn(conn)
+	}
+}
+
+// Stop forgets the keys and closes the listener of Serve.
+func (a *Agent) Stop() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.keys = nil
+	a.stopped = true
+	if a.listener != nil {
+		a.listener.Close()
+	}
+}
+
+func (a *Agent) serveConn(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err := checkPeer(conn); err != nil {
+		return
+	}
+
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	var resp agentResponse
+	switch req.Op {
+	case agentPing:
+	case agentDecrypt:
+		ek, err := a.decryptKey(req.Packet)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.CipherFunc, resp.Key = ek.CipherFunc, ek.Key
+		}
+	default:
+		resp.Error = fmt.Sprintf("unknown operation %q", req.Op)
+	}
+	json.NewEncoder(conn).Encode(&resp)
+}
+
+// decryptKey decrypts the encrypted key packet b with the first of the
+// agent's keys that can.
+func (a *Agent) decryptKey(b []byte) (*packet.EncryptedKey, error) {
+	p, err := packet.Read(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	ek, ok := p.(*packet.EncryptedKey)
+	if !ok {
+		return nil, errors.New("not an encrypted key packet")
+	}
+
+	a.mu.Lock()
+	var keys []openpgp.Key
+	if ek.KeyId == 0 {
+		keys = a.keys.DecryptionKeys()
+	} else {
+		keys = a.keys.KeysById(ek.KeyId)
+	}
+	a.mu.Unlock()
+
+	for _, k := range keys {
+		if k.PrivateKey == nil || k.PrivateKey.Encrypted {
+			continue
+		}
+		if err := ek.Decrypt(k.PrivateKey, nil); err == nil {
+			return ek, nil
+		}
+	}
+	return nil, errNoPrivateKey
+}
+
+// AgentClient is the KeyAgent of an agent listening on a unix socket.
+type AgentClient struct {
+	SocketPath string
+}
+
+// DialAgent returns a client for the agent listening on socketPath, or an
+// error if there is none. The socket and its directory must belong to the
+// user, as checked by checkAgentSocket, and on Linux so must the process
+// listening on it; otherwise an *UnsafeAgentError is returned.
+func DialAgent(socketPath string) (*AgentClient, error) {
+	if err := checkAgentSocket(socketPath); err != nil {
+		return nil, err
+	}
+	client := &AgentClient{socketPath}
+	if _, err := client.call(&agentRequest{Op: agentPing}); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+// DecryptKey asks the agent to decrypt ek. The private keys and the password
+// never leave the agent.
+func (client *AgentClient) DecryptKey(ek *packet.EncryptedKey) error {
+	var b bytes.Buffer
+	if err := ek.Serialize(&b); err != nil {
+		return err
+	}
+	resp, err := client.call(&agentRequest{Op: agentDecrypt, Packet: b.Bytes()})
+	if err != nil {
+		return err
+	}
+	ek.CipherFunc, ek.Key = resp.CipherFunc, resp.Key
+	return nil
+}
+
+func (client *AgentClient) call(req *agentRequest) (*agentResponse, error) {
+	conn, err := net.DialTimeout("unix", client.SocketPath, agentTimeout)
+	if err != nil {
+		return nil, &AgentError{err}
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(agentTimeout))
+	if err := checkPeer(conn); err != nil {
+		return nil, &UnsafeAgentError{client.SocketPath, err.Error()}
+	}
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, &AgentError{err}
+	}
+	var resp agentResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, &AgentError{err}
+	}
+	if resp.Error != "" {
+		if resp.Error == errNoPrivateKey.Error() {
+			return nil, errNoPrivateKey
+		}
+		return nil, &AgentError{errors.New(resp.Error)}
+	}
+	return &resp, nil
+}
+
+// isAgentFailure reports whether err means the agent could not be used, as
+// opposed to it having no key for a secret or the secret being invalid.
+func isAgentFailure(err error) bool {
+	switch err.(type) {
+	case *AgentError, *UnsafeAgentError:
+		return true
+	}
+	return false
+}
+
+// dropAgent stops using ctx.Agent after it failed with err, warning about it,
+// and gets the password from ctx.Prompt if it is not known yet. err is
+// returned when there is no way to get the password.
+func (ctx *SecureContext) dropAgent(err error) error {
+	ctx.Agent = nil
+	if ctx.Password != "" {
+		ctx.warn(err)
+		return nil
+	}
+	if ctx.Prompt == nil {
+		return err
+	}
+	ctx.warn(err)
+	password, perr := ctx.Prompt()
+	if perr != nil {
+		return perr
+	}
+	ctx.Password = password
+	return nil
+}
+
+// decryptWithAgent reads the encrypted message from r, decrypting its
+// session key with ctx.Agent. The MDC of the message is checked once its
+// body has been read to EOF, like openpgp.ReadMessage does.
+func (ctx *SecureContext) decryptWithAgent(r io.Reader) (*openpgp.MessageDetails, error) {
+	packets := packet.NewReader(r)
+	var encryptedKeys []*packet.EncryptedKey
+	var keyIds []uint64
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			encryptedKeys = append(encryptedKeys, p)
+			keyIds = append(keyIds, p.KeyId)
+		case *packet.SymmetricallyEncrypted:
+			for _, ek := range encryptedKeys {
+				if err := ctx.Agent.DecryptKey(ek); err == errNoPrivateKey {
+					continue
+				} else if err != nil {
+					return nil, err
+				}
+				decrypted, err := p.Decrypt(ek.CipherFunc, ek.Key)
+				if err != nil {
+					return nil, err
+				}
+				md, err := openpgp.ReadMessage(decrypted, ctx.keyRing(), nil, nil)
+				if err != nil {
+					return nil, err
+				}
+				md.IsEncrypted = true
+				md.EncryptedToKeyIds = keyIds
+				if keys := ctx.PrivateRing.KeysById(ek.KeyId); len(keys) > 0 {
+					md.DecryptedWith = keys[0]
+				}
+				md.UnverifiedBody = &mdcCheckReader{body: md.UnverifiedBody, decrypted: decrypted}
+				return md, nil
+			}
+			return nil, errNoPrivateKey
+		default:
+			return nil, pgperrors.StructuralError("unexpected packet before the encrypted data")
+		}
+	}
+}
+
+// mdcCheckReader closes the decrypted data at EOF, which checks its MDC.
+// Reads after EOF return the same result instead of reading on.
+type mdcCheckReader struct {
+	body      io.Reader
+	decrypted io.ReadCloser
+	err       error
+}
+
+func (r *mdcCheckReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.body.Read(p)
+	if err == io.EOF {
+		if closeErr := r.decrypted.Close(); closeErr != nil {
+			err = closeErr
+		}
+	}
+	r.err = err
+	return n, err
+}
@@ -0,0 +1,140 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// startTestAgent runs an agent holding the private keys of alice on a socket
+// in a new private directory until the test ends.
+func startTestAgent(t *testing.T) (*Agent, string) {
+	t.Helper()
+	socketPath := filepath.Join(t.TempDir(), "gosec", "agent.sock")
+	l, err := ListenAgent(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewSecureContext(testSecureRing, testPublicRing, "")
+	if err := ctx.ReadKeyRing(); err != nil {
+		t.Fatal(err)
+	}
+	agent, err := NewAgent(ctx.PrivateRing, testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- agent.Serve(l, time.Minute) }()
+	t.Cleanup(func() {
+		agent.Stop()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	})
+	return agent, socketPath
+}
+
+func TestAgentDecrypt(t *testing.T) {
+	agent, socketPath := startTestAgent(t)
+	if fi, err := os.Stat(filepath.Dir(socketPath)); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("agent directory: %v, %v, want mode 0700", fi.Mode(), err)
+	}
+
+	writer := newTestContext(t)
+	writeTestSecret(t, writer, "logins", "hunter2\n")
+	// A context of its own, whose private keys stay locked.
+	ctx := newTestContextFor(t, writer.DirectoryRoot, testSecureRing)
+	ctx.Password = ""
+	var prompts int
+	ctx.Prompt = func() (string, error) {
+		prompts++
+		return testPassword, nil
+	}
+	var warnings []error
+	ctx.Warn = func(err error) { warnings = append(warnings, err) }
+
+	client, err := DialAgent(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx.Agent = client
+	plaintext, err := ctx.ReadSecret(ctx.SecretPath("logins"))
+	if err != nil || string(plaintext) != "hunter2\n" {
+		t.Fatalf("through the agent: got %q, %v", plaintext, err)
+	}
+	if prompts != 0 {
+		t.Error("asked for the password with an agent")
+	}
+
+	// Once the agent is gone, the password is asked for instead.
+	agent.Stop()
+	plaintext, err = ctx.ReadSecret(ctx.SecretPath("logins"))
+	if err != nil || string(plaintext) != "hunter2\n" {
+		t.Fatalf("without the agent: got %q, %v", plaintext, err)
+	}
+	if prompts != 1 || ctx.Agent != nil {
+		t.Errorf("asked for the password %d times, agent %v", prompts, ctx.Agent)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("got warnings %v, want one", warnings)
+	}
+	if _, ok := warnings[0].(*AgentError); !ok {
+		t.Errorf("got warning %v, want an *AgentError", warnings[0])
+	}
+}
+
+func TestListenAgentUnsafeDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions")
+	}
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenAgent(filepath.Join(dir, "agent.sock")); err == nil {
+		t.Fatal("listening in a directory others can access")
+	} else if _, ok := err.(*UnsafeAgentError); !ok {
+		t.Fatalf("got %v, want an *UnsafeAgentError", err)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("existing directory changed to %v, %v", fi.Mode(), err)
+	}
+}
+
+func TestAgentRefusesOwner(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of a directory needs root")
+	}
+	_, socketPath := startTestAgent(t)
+	dir := filepath.Dir(socketPath)
+	if err := os.Chown(dir, 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chown(dir, 0, 0)
+
+	if _, err := DialAgent(socketPath); err == nil {
+		t.Error("dialed an agent in a directory of another user")
+	} else if _, ok := err.(*UnsafeAgentError); !ok {
+		t.Errorf("dial: got %v, want an *UnsafeAgentError", err)
+	}
+	if _, err := ListenAgent(filepath.Join(dir, "other.sock")); err == nil {
+		t.Error("listening in a directory of another user")
+	} else if _, ok := err.(*UnsafeAgentError); !ok {
+		t.Errorf("listen: got %v, want an *UnsafeAgentError", err)
+	}
+}
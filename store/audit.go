@@ -0,0 +1,122 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// AuditRecord reports a secret whose recipients differ from the access list.
+type AuditRecord struct {
+	SecretRecord
+	Missing    []string `json:"missing"`
+	Unexpected []string `json:"unexpected"`
+}
+
+// entityKeyIds returns the ids of the primary key and subkeys of entity.
+func entityKeyIds(entity *openpgp.Entity) []uint64 {
+	keyIds := []uint64{entity.PrimaryKey.KeyId}
+	for _, subkey := range entity.Subkeys {
+		keyIds = append(keyIds, subkey.PublicKey.KeyId)
+	}
+	return keyIds
+}
+
+// compareRecipients returns the entities of entityList that none of keyIds
+// belong to, and the keyIds that belong to no entity of entityList.
+func compareRecipients(entityList openpgp.EntityList, keyIds []uint64) ([]*openpgp.Entity, []uint64) {
+	var missing []*openpgp.Entity
+	var unexpected []uint64
+
+	expected := map[uint64]bool{}
+	for _, entity := range entityList {
+		found := false
+		for _, keyId := range entityKeyIds(entity) {
+			expected[keyId] = true
+			for _, recipient := range keyIds {
+				found = found || recipient == keyId
+			}
+		}
+		if !found {
+			missing = append(missing, entity)
+		}
+	}
+	for _, recipient := range keyIds {
+		if !expected[recipient] {
+			unexpected = append(unexpected, recipient)
+		}
+	}
+	return missing, unexpected
+}
+
+// Audit compares the recipients of every secret with the access list and
+// calls fn for each secret that is missing a recipient or has an unexpected
+// one. Secrets are not decrypted.
+func (ctx *SecureContext) Audit(fn func(AuditRecord) error) error {
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		return err
+	}
+
+	return ctx.WalkSecrets(func(filePath string) error {
+		keyIds, err := ReadRecipients(filePath)
+		if err != nil {
+			return err
+		}
+
+		record := ctx.auditSecret(filePath, keyIds, entityList)
+		if record.OK() {
+			return nil
+		}
+		return fn(record)
+	})
+}
+
+// auditSecret compares keyIds, the recipients of the secret at filePath, with
+// entityList.
+func (ctx *SecureContext) auditSecret(filePath string, keyIds []uint64, entityList openpgp.EntityList) AuditRecord {
+	record := AuditRecord{
+		SecretRecord: ctx.secretRecord(filePath, keyIds, nil),
+		Missing:      []string{},
+		Unexpected:   []string{},
+	}
+
+	missing, unexpected := compareRecipients(entityList, keyIds)
+	for _, entity := range missing {
+		record.Missing = append(record.Missing, KeyName(ctx.PublicRing, entity.PrimaryKey.KeyId))
+	}
+	for _, keyId := range unexpected {
+		record.Unexpected = append(record.Unexpected, KeyName(ctx.PublicRing, keyId))
+	}
+	return record
+}
+
+// OK reports whether the secret is encrypted to exactly the access list.
+func (record AuditRecord) OK() bool {
+	return len(record.Missing) == 0 && len(record.Unexpected) == 0
+}
+
+// Problems describes the differences with the access list.
+func (record AuditRecord) Problems() string {
+	var problems []string
+	if len(record.Missing) > 0 {
+		problems = append(problems, "missing "+strings.Join(record.Missing, ", "))
+	}
+	if len(record.Unexpected) > 0 {
+		problems = append(problems, "unexpected "+strings.Join(record.Unexpected, ", "))
+	}
+	return strings.Join(problems, "; ")
+}
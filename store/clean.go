@@ -0,0 +1,83 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var errRoundTrip = errors.New("encrypted copy does not decrypt to the plaintext, not removing it")
+
+// ShredFile overwrites the contents of the file at filePath with random data,
+// flushes it to disk and removes it. Copy-on-write and journaling file
+// systems may still keep the old blocks; prefer -to tmpfs where that matters.
+func ShredFile(filePath string) error {
+	fp, err := os.OpenFile(filePath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	fi, err := fp.Stat()
+	if err != nil {
+		fp.Close()
+		return err
+	}
+
+	_, err = io.CopyN(fp, rand.Reader, fi.Size())
+	if err == nil {
+		err = fp.Sync()
+	}
+	if closeErr := fp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(filePath)
+}
+
+// VerifyEncrypted reports an error unless the ciphertext at cipherPath
+// decrypts, with a valid signature if signed, to the contents of plainPath.
+func (ctx *SecureContext) VerifyEncrypted(plainPath, cipherPath string) error {
+	plaintext, err := ioutil.ReadFile(plainPath)
+	if err != nil {
+		return err
+	}
+	decrypted, err := ctx.ReadSecret(cipherPath)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(plaintext, decrypted) {
+		return errRoundTrip
+	}
+	return nil
+}
+
+// Clean shreds every plaintext file EncryptRoot would encrypt, once its
+// encrypted counterpart has been decrypted and compared with it. Files that
+// fail the comparison are kept and reported as errors.
+func (ctx *SecureContext) Clean() error {
+	return ctx.walkPlaintext(func(filePath string) error {
+		if err := ctx.VerifyEncrypted(filePath, ctx.ciphertextPath(filePath)); err != nil {
+			return err
+		}
+		return ShredFile(filePath)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// How a conflict between plaintext and ciphertext is reported. The file is
+// never overwritten in either direction; ConflictDiff additionally returns a
+// diff and ConflictFile writes the upstream plaintext next to the local one.
+const (
+	ConflictRefuse = "refuse"
+	ConflictDiff   = "diff"
+	ConflictFile   = "file"
+)
+
+// ConflictSuffix is appended to a plaintext path for ConflictFile.
+const ConflictSuffix = ".conflict"
+
+var (
+	errLocalChanges = errors.New("plaintext has local changes that are not encrypted yet, skipped")
+	errStale        = errors.New("ciphertext changed since the plaintext was decrypted, decrypt it first")
+)
+
+// ConflictError is returned when both the plaintext and the ciphertext of a
+// secret changed since they were last synchronized.
+type ConflictError struct {
+	Path string
+	// Diff is the diff of the local and upstream plaintext with ConflictDiff.
+	Diff []byte
+}
+
+func (e *ConflictError) Error() string {
+	return "plaintext and ciphertext both changed since the last sync"
+}
+
+// ValidConflictMode returns an error unless mode is one of the Conflict
+// constants.
+func ValidConflictMode(mode string) error {
+	switch mode {
+	case ConflictRefuse, ConflictDiff, ConflictFile:
+		return nil
+	}
+	return fmt.Errorf("unknown conflict mode %q", mode)
+}
+
+// conflict reports that local and upstream, the decrypted ciphertext, of the
+// plaintext file at plainPath diverged. It always returns a ConflictError.
+func (ctx *SecureContext) conflict(plainPath string, local, upstream []byte) error {
+	conflictErr := &ConflictError{Path: plainPath}
+	switch ctx.ConflictMode {
+	case ConflictDiff:
+		var diff bytes.Buffer
+		writeDiff(&diff, plainPath, plainPath+" (upstream)", local, upstream)
+		conflictErr.Diff = diff.Bytes()
+	case ConflictFile:
+		err := WriteFileAtomic(plainPath+ConflictSuffix, PlaintextFileMode, func(w io.Writer) error {
+			_, err := w.Write(upstream)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return conflictErr
+}
+
+// Resolve marks the conflicts of the named secrets as resolved once their
+// plaintext was merged by hand: the current ciphertext is recorded as the
+// synchronized version, so that EncryptRoot encrypts the merged plaintext over
+// it, and the ConflictFile copy is removed.
+func (ctx *SecureContext) Resolve(names []string) error {
+	state, err := ctx.LoadState()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".gpg")
+		cipherPath := ctx.SecretPath(name)
+		ciphertext, err := ioutil.ReadFile(cipherPath)
+		if err != nil {
+			return err
+		}
+		plainPath := ctx.plaintextPath(cipherPath)
+		if _, err := os.Stat(plainPath); err != nil {
+			return err
+		}
+		upstream, err := ctx.ReadSecret(cipherPath)
+		if err != nil {
+			return &FileError{cipherPath, err}
+		}
+		if err := os.Remove(plainPath + ConflictSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		state.Record(name, ciphertext, upstream)
+		ctx.progress(SecretResolved, name)
+	}
+	return state.Save()
+}
+
+func splitLines(b []byte) []string {
+	s := string(b)
+	if s == "" {
+		return nil
+	}
+	return strings.SplitAfter(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// lcsTable returns the table of the lengths of the longest common
+// subsequences of every suffix of linesA and linesB. Secrets are small, so
+// the quadratic table is fine.
+func lcsTable(linesA, linesB []string) [][]int {
+	lcs := make([][]int, len(linesA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesB)+1)
+	}
+	for i := len(linesA) - 1; i >= 0; i-- {
+		for j := len(linesB) - 1; j >= 0; j-- {
+			if linesA[i] == linesB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	return lcs
+}
+
+// writeDiff writes a line diff of a and b, computed from their longest common
+// subsequence.
+func writeDiff(w io.Writer, nameA, nameB string, a, b []byte) {
+	linesA, linesB := splitLines(a), splitLines(b)
+	lcs := lcsTable(linesA, linesB)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "--- %v\n+++ %v\n", nameA, nameB)
+	writeLine := func(prefix, line string) {
+		buf.WriteString(prefix + strings.TrimSuffix(line, "\n") + "\n")
+	}
+	i, j := 0, 0
+	for i < len(linesA) && j < len(linesB) {
+		switch {
+		case linesA[i] == linesB[j]:
+			writeLine(" ", linesA[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			writeLine("-", linesA[i])
+			i++
+		default:
+			writeLine("+", linesB[j])
+			j++
+		}
+	}
+	for ; i < len(linesA); i++ {
+		writeLine("-", linesA[i])
+	}
+	for ; j < len(linesB); j++ {
+		writeLine("+", linesB[j])
+	}
+	w.Write(buf.Bytes())
+}
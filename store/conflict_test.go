@@ -0,0 +1,209 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// syncTestSecret returns a context whose logins.txt secret was decrypted
+// with base, unless neverSynced, in which case the plaintext is written
+// without recording it, as by versions without a state file. A non empty
+// local then replaces the plaintext and a non empty upstream the ciphertext.
+func syncTestSecret(t *testing.T, neverSynced bool, base, local, upstream string) *SecureContext {
+	t.Helper()
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "logins.txt", base)
+	plainPath := filepath.Join(ctx.DirectoryRoot, "logins.txt")
+	if neverSynced {
+		writeTestFile(t, plainPath, base)
+	} else if err := ctx.DecryptRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if local != "" {
+		writeTestFile(t, plainPath, local)
+	}
+	if upstream != "" {
+		writeTestSecret(t, ctx, "logins.txt", upstream)
+	}
+	return ctx
+}
+
+// checkSecret fails unless the plaintext and the decrypted ciphertext of
+// logins.txt are as wanted.
+func checkSecret(t *testing.T, ctx *SecureContext, wantPlain, wantCipher string) {
+	t.Helper()
+	plaintext, err := ioutil.ReadFile(filepath.Join(ctx.DirectoryRoot, "logins.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := ctx.ReadSecret(ctx.SecretPath("logins.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != wantPlain || string(upstream) != wantCipher {
+		t.Errorf("plaintext %q and ciphertext %q, want %q and %q", plaintext, upstream, wantPlain, wantCipher)
+	}
+}
+
+// fileErr returns the error a walk failed with for a single file.
+func fileErr(err error) error {
+	if fileErr, ok := err.(*FileError); ok {
+		return fileErr.Err
+	}
+	return err
+}
+
+func TestDecryptRootState(t *testing.T) {
+	t.Run("never synced", func(t *testing.T) {
+		ctx := syncTestSecret(t, true, "base\n", "edit\n", "")
+		var warnings []error
+		ctx.Warn = func(err error) { warnings = append(warnings, err) }
+		if err := ctx.DecryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		if len(warnings) != 1 || fileErr(warnings[0]) != errLocalChanges {
+			t.Errorf("got warnings %v, want %v", warnings, errLocalChanges)
+		}
+		checkSecret(t, ctx, "edit\n", "base\n")
+	})
+	t.Run("never synced, same plaintext", func(t *testing.T) {
+		ctx := syncTestSecret(t, true, "base\n", "", "")
+		if err := ctx.DecryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		state, _ := ctx.LoadState()
+		if _, ok := state.Secrets["logins.txt"]; !ok {
+			t.Error("secret not recorded in the state file")
+		}
+	})
+	t.Run("local change", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "edit\n", "")
+		if err := ctx.DecryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		checkSecret(t, ctx, "edit\n", "base\n")
+	})
+	t.Run("upstream change", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "", "upstream\n")
+		if err := ctx.DecryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		checkSecret(t, ctx, "upstream\n", "upstream\n")
+	})
+	t.Run("both changed", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "edit\n", "upstream\n")
+		ctx.ConflictMode = ConflictFile
+		err := ctx.DecryptRoot()
+		if _, ok := fileErr(err).(*ConflictError); !ok {
+			t.Fatalf("got %v, want a conflict", err)
+		}
+		checkSecret(t, ctx, "edit\n", "upstream\n")
+		conflict, _ := ioutil.ReadFile(filepath.Join(ctx.DirectoryRoot, "logins.txt"+ConflictSuffix))
+		if string(conflict) != "upstream\n" {
+			t.Errorf("conflict file holds %q, want the upstream plaintext", conflict)
+		}
+	})
+}
+
+func TestEncryptRootState(t *testing.T) {
+	t.Run("never synced", func(t *testing.T) {
+		ctx := syncTestSecret(t, true, "base\n", "edit\n", "")
+		if err := ctx.EncryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		checkSecret(t, ctx, "edit\n", "edit\n")
+	})
+	t.Run("unchanged", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "", "")
+		before, _ := ioutil.ReadFile(ctx.SecretPath("logins.txt"))
+		var statuses []string
+		ctx.Progress = func(status, name string) { statuses = append(statuses, status) }
+		if err := ctx.EncryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := ioutil.ReadFile(ctx.SecretPath("logins.txt"))
+		if string(before) != string(after) || len(statuses) != 1 || statuses[0] != SecretUnchanged {
+			t.Errorf("ciphertext rewritten, statuses %q", statuses)
+		}
+	})
+	t.Run("local change", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "edit\n", "")
+		if err := ctx.EncryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		checkSecret(t, ctx, "edit\n", "edit\n")
+	})
+	t.Run("upstream change", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "", "upstream\n")
+		if err := ctx.EncryptRoot(); fileErr(err) != errStale {
+			t.Fatalf("got %v, want %v", err, errStale)
+		}
+		checkSecret(t, ctx, "base\n", "upstream\n")
+	})
+	t.Run("both changed", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "edit\n", "upstream\n")
+		if _, ok := fileErr(ctx.EncryptRoot()).(*ConflictError); !ok {
+			t.Fatal("got no conflict")
+		}
+		checkSecret(t, ctx, "edit\n", "upstream\n")
+	})
+	t.Run("resolved", func(t *testing.T) {
+		ctx := syncTestSecret(t, false, "base\n", "edit\n", "upstream\n")
+		writeTestFile(t, filepath.Join(ctx.DirectoryRoot, "logins.txt"), "merged\n")
+		if err := ctx.Resolve([]string{"logins.txt"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := ctx.EncryptRoot(); err != nil {
+			t.Fatal(err)
+		}
+		checkSecret(t, ctx, "merged\n", "merged\n")
+	})
+}
+
+func TestSyncState(t *testing.T) {
+	ctx := newTestContext(t)
+	state, err := ctx.LoadState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.UpstreamChanged("s", []byte("c")) || !state.LocalChanged("s", []byte("p")) {
+		t.Error("a secret never synced should be taken as a local change only")
+	}
+
+	state.Record("s", []byte("c"), []byte("p"))
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if state, err = ctx.LoadState(); err != nil {
+		t.Fatal(err)
+	}
+	if state.UpstreamChanged("s", []byte("c")) || state.LocalChanged("s", []byte("p")) {
+		t.Error("recorded version reported as changed")
+	}
+	if !state.UpstreamChanged("s", []byte("c2")) || !state.LocalChanged("s", []byte("p2")) {
+		t.Error("changed version reported as unchanged")
+	}
+
+	fi, err := os.Stat(filepath.Join(ctx.DirectoryRoot, StateFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("state file mode %#o, want 0600", fi.Mode().Perm())
+	}
+}
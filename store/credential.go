@@ -0,0 +1,293 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultCredentialName is the naming convention of the secrets holding git
+// credentials, as expanded by CredentialName.
+const DefaultCredentialName = "git/{host}"
+
+// Credential holds the attributes of the git credential helper protocol
+// that gosec uses.
+type Credential struct {
+	Protocol string
+	Host     string
+	Path     string
+	Username string
+	Password string
+}
+
+// ReadCredential reads attribute=value lines, as git writes them to a
+// credential helper, up to a blank line or EOF. A url attribute sets the
+// attributes it is made of; unknown attributes are ignored.
+func ReadCredential(r io.Reader) (*Credential, error) {
+	c := &Credential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid credential line %q", line)
+		}
+		value := line[i+1:]
+		switch line[:i] {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, err
+			}
+			c.Protocol, c.Host, c.Path = u.Scheme, u.Host, strings.TrimPrefix(u.Path, "/")
+			if u.User != nil {
+				c.Username = u.User.Username()
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if c.Host == "" {
+		return nil, fmt.Errorf("credential without a host")
+	}
+	return c, nil
+}
+
+// Encode writes the username and password of c for git. Values holding a
+// newline or NUL byte, which the protocol cannot carry, are refused.
+func (c *Credential) Encode(w io.Writer) error {
+	if strings.ContainsAny(c.Username+c.Password, "\n\x00") {
+		return fmt.Errorf("credential for %v contains a newline or NUL byte", c.Host)
+	}
+	bw := bufio.NewWriter(w)
+	if c.Username != "" {
+		fmt.Fprintf(bw, "username=%v\n", c.Username)
+	}
+	fmt.Fprintf(bw, "password=%v\n", c.Password)
+	return bw.Flush()
+}
+
+// fields returns c as a structured secret.
+func (c *Credential) fields() Fields {
+	fields := Fields{}
+	for _, field := range []*Field{
+		{Name: "protocol", Value: c.Protocol},
+		{Name: "host", Value: c.Host},
+		{Name: "path", Value: c.Path},
+		{Name: "username", Value: c.Username},
+		{Name: "password", Value: c.Password},
+	} {
+		if field.Value != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// CredentialName expands the {protocol}, {host}, {path} and {username}
+// placeholders of pattern, such as DefaultCredentialName, with the
+// attributes of c and returns the name of the secret holding it.
+func CredentialName(pattern string, c *Credential) (string, error) {
+	name := strings.NewReplacer(
+		"{protocol}", c.Protocol,
+		"{host}", c.Host,
+		"{path}", c.Path,
+		"{username}", c.Username,
+	).Replace(pattern)
+	name = path.Clean(name)
+	if name == "." || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("credential name %q is outside the files directory", name)
+	}
+	return name, nil
+}
+
+// credentialFrom returns the credential held by the fields of a secret
+// when their host field is c.Host, they have a password field and, if c has
+// a username, their username field is either missing or the same. Blocks are
+// searched depth first.
+func credentialFrom(fields Fields, c *Credential) *Credential {
+	if host, err := fields.Lookup("host"); err == nil && host.Value == c.Host {
+		found := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+		if username, err := fields.Lookup("username"); err == nil {
+			found.Username = username.Value
+		}
+		password, err := fields.Lookup("password")
+		if err == nil && (c.Username == "" || found.Username == c.Username) {
+			found.Password = password.Value
+			return found
+		}
+	}
+	for _, field := range fields {
+		if field.Fields != nil {
+			if found := credentialFrom(field.Fields, c); found != nil {
+				return found
+			}
+		}
+	}
+	return nil
+}
+
+// readCredential returns the credential held by the named secret: its
+// username and password fields or, for a free-form secret, its first line
+// as the password. It returns nil if the secret does not exist.
+func (ctx *SecureContext) readCredential(name string, c *Credential) (*Credential, error) {
+	plaintext, err := ctx.ReadSecret(ctx.SecretPath(name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	found := &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}
+	fields, err := ParseFields(plaintext)
+	switch {
+	case err == ErrNotStructured:
+		line := strings.SplitN(string(plaintext), "\n", 2)[0]
+		found.Password = strings.TrimSuffix(line, "\r")
+	case err != nil:
+		return nil, fmt.Errorf("%v: %v", name, err)
+	default:
+		if username, err := fields.Lookup("username"); err == nil {
+			found.Username = username.Value
+		}
+		password, err := fields.Lookup("password")
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", name, err)
+		}
+		found.Password = password.Value
+	}
+	return found, nil
+}
+
+// FindCredential returns the credential for c: the secret named by pattern,
+// as expanded by CredentialName, or else, also when that secret is for
+// another username, the first structured secret with a matching host and
+// username, as found by a walk of the files directory. Secrets that cannot be
+// decrypted or parsed are skipped by the walk. It returns nil when there is
+// no credential for c.
+func (ctx *SecureContext) FindCredential(pattern string, c *Credential) (*Credential, error) {
+	name, err := CredentialName(pattern, c)
+	if err != nil {
+		return nil, err
+	}
+	if found, err := ctx.readCredential(name, c); err != nil {
+		return nil, err
+	} else if found != nil && (c.Username == "" || found.Username == c.Username) {
+		return found, nil
+	}
+	// A new project has no files directory until its first secret.
+	if _, err := os.Stat(ctx.FilesPath()); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	var found *Credential
+	err = ctx.WalkSecrets(func(filePath string) error {
+		if found != nil {
+			return nil
+		}
+		plaintext, err := ctx.ReadSecret(filePath)
+		if err != nil {
+			return nil
+		}
+		if fields, err := ParseFields(plaintext); err == nil {
+			found = credentialFrom(fields, c)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// StoreCredential encrypts c to the access list as the secret named by
+// pattern. Nothing is written when FindCredential already returns the same
+// username and password, so that approving a credential does not churn the
+// ciphertext.
+func (ctx *SecureContext) StoreCredential(pattern string, c *Credential) error {
+	if c.Password == "" {
+		return fmt.Errorf("credential for %v without a password", c.Host)
+	}
+	if found, err := ctx.FindCredential(pattern, c); err != nil {
+		return err
+	} else if found != nil && found.Username == c.Username && found.Password == c.Password {
+		return nil
+	}
+
+	name, err := CredentialName(pattern, c)
+	if err != nil {
+		return err
+	}
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		return err
+	}
+	destPath := ctx.SecretPath(name)
+	if err := os.MkdirAll(filepath.Dir(destPath), 0700); err != nil {
+		return err
+	}
+	status := SecretCreated
+	if _, err := os.Stat(destPath); err == nil {
+		status = SecretUpdated
+	}
+	plaintext := []byte(c.fields().String())
+	err = WriteFileAtomic(destPath, 0644, func(w io.Writer) error {
+		return ctx.Encrypt(w, plaintext, entityList)
+	})
+	if err != nil {
+		return err
+	}
+	ctx.progress(status, name)
+	return ctx.UpdateGitignore()
+}
+
+// EraseCredential removes the secret named by pattern when it holds c, the
+// username and password of c being either empty or the same. Secrets found
+// through a host field are never removed.
+func (ctx *SecureContext) EraseCredential(pattern string, c *Credential) error {
+	name, err := CredentialName(pattern, c)
+	if err != nil {
+		return err
+	}
+	found, err := ctx.readCredential(name, c)
+	if found == nil || err != nil {
+		return err
+	}
+	if c.Username != "" && found.Username != c.Username || c.Password != "" && found.Password != c.Password {
+		return nil
+	}
+	if err := os.Remove(ctx.SecretPath(name)); err != nil {
+		return err
+	}
+	ctx.progress(SecretRemoved, name)
+	return ctx.UpdateGitignore()
+}
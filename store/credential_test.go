@@ -0,0 +1,173 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadCredential(t *testing.T) {
+	c, err := ReadCredential(strings.NewReader("protocol=https\nhost=example.com\nusername=alice\nunknown=x\n\nhost=ignored\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != (Credential{Protocol: "https", Host: "example.com", Username: "alice"}) {
+		t.Errorf("got %+v", c)
+	}
+
+	c, err = ReadCredential(strings.NewReader("url=https://bob@git.example.com/team/repo.git\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *c != (Credential{Protocol: "https", Host: "git.example.com", Path: "team/repo.git", Username: "bob"}) {
+		t.Errorf("from a url: got %+v", c)
+	}
+
+	for _, input := range []string{"protocol=https\n", "host\n"} {
+		if _, err := ReadCredential(strings.NewReader(input)); err == nil {
+			t.Errorf("%q read", input)
+		}
+	}
+}
+
+func TestCredentialEncode(t *testing.T) {
+	var b bytes.Buffer
+	if err := (&Credential{Host: "h", Username: "alice", Password: "pw"}).Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "username=alice\npassword=pw\n" {
+		t.Errorf("got %q", b.String())
+	}
+	if err := (&Credential{Host: "h", Password: "pw\nhost=evil"}).Encode(&b); err == nil {
+		t.Error("encoded a password holding a newline")
+	}
+}
+
+func TestCredentialName(t *testing.T) {
+	c := &Credential{Protocol: "https", Host: "example.com", Username: "alice"}
+	if name, err := CredentialName("git/{protocol}/{host}/{username}", c); err != nil || name != "git/https/example.com/alice" {
+		t.Errorf("got %q, %v", name, err)
+	}
+	for _, host := range []string{"..", "../x", ""} {
+		if name, err := CredentialName("{host}", &Credential{Host: host}); err == nil {
+			t.Errorf("host %q: got %q, want an error", host, name)
+		}
+	}
+}
+
+// findTestCredential returns the username and password FindCredential finds
+// for host and username, or "none".
+func findTestCredential(t *testing.T, ctx *SecureContext, host, username string) string {
+	t.Helper()
+	found, err := ctx.FindCredential(DefaultCredentialName, &Credential{Protocol: "https", Host: host, Username: username})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found == nil {
+		return "none"
+	}
+	return found.Username + ":" + found.Password
+}
+
+func TestFindCredential(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "git/example.com", "username: alice\npassword: a1\n")
+	writeTestSecret(t, ctx, "git/free.example.com", "f1\nnot the password\n")
+	writeTestSecret(t, ctx, "accounts", `accountB:
+  host: example.com
+  username: bob
+  password: b1
+accountC:
+  host: other.example.com
+  password: c1
+`)
+
+	for _, test := range []struct {
+		host, username, want string
+	}{
+		{"example.com", "", "alice:a1"},
+		{"example.com", "alice", "alice:a1"},
+		// Another username than the named secret's is looked for further.
+		{"example.com", "bob", "bob:b1"},
+		{"example.com", "carol", "none"},
+		{"free.example.com", "", ":f1"},
+		{"free.example.com", "alice", "alice:f1"},
+		{"other.example.com", "dave", "dave:c1"},
+		{"missing.example.com", "", "none"},
+	} {
+		if got := findTestCredential(t, ctx, test.host, test.username); got != test.want {
+			t.Errorf("%v@%v: got %v, want %v", test.username, test.host, got, test.want)
+		}
+	}
+}
+
+func TestStoreAndEraseCredential(t *testing.T) {
+	ctx := newTestContext(t)
+	var progress []string
+	ctx.Progress = func(status, name string) { progress = append(progress, status+" "+name) }
+	c := &Credential{Protocol: "https", Host: "example.com", Username: "alice", Password: "a1"}
+
+	if err := ctx.StoreCredential(DefaultCredentialName, c); err != nil {
+		t.Fatal(err)
+	}
+	if got := findTestCredential(t, ctx, "example.com", "alice"); got != "alice:a1" {
+		t.Errorf("stored %v", got)
+	}
+	ciphertext, err := ioutil.ReadFile(ctx.SecretPath("git/example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Storing the same credential again leaves the ciphertext alone.
+	if err := ctx.StoreCredential(DefaultCredentialName, c); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(ctx.SecretPath("git/example.com")); !bytes.Equal(b, ciphertext) {
+		t.Error("ciphertext rewritten for a known credential")
+	}
+	if err := ctx.StoreCredential(DefaultCredentialName, &Credential{Host: "example.com"}); err == nil {
+		t.Error("stored a credential without a password")
+	}
+
+	// Only the credential held by the secret is erased.
+	for _, rejected := range []*Credential{
+		{Host: "example.com", Username: "bob"},
+		{Host: "example.com", Username: "alice", Password: "old"},
+	} {
+		if err := ctx.EraseCredential(DefaultCredentialName, rejected); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(ctx.SecretPath("git/example.com")); err != nil {
+		t.Fatalf("erased another credential: %v", err)
+	}
+	if err := ctx.EraseCredential(DefaultCredentialName, c); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(ctx.SecretPath("git/example.com")); !os.IsNotExist(err) {
+		t.Errorf("credential not erased: %v", err)
+	}
+	if err := ctx.EraseCredential(DefaultCredentialName, c); err != nil {
+		t.Errorf("erasing a missing credential: %v", err)
+	}
+
+	want := []string{SecretCreated + " git/example.com", SecretRemoved + " git/example.com"}
+	if strings.Join(progress, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got progress %q, want %q", progress, want)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package store reads and writes gosec secret stores: directories holding
+// an access list and, under files/, secrets encrypted to it with OpenPGP.
+//
+// A SecureContext is created for a store with NewSecureContext and unlocked
+// by reading its key rings and setting its Password:
+//
+//	ctx := store.NewSecureContext(store.DefaultSecureRingPath, store.DefaultPublicRingPath, dir)
+//	if err := ctx.ReadKeyRing(); err != nil {
+//		return err
+//	}
+//	ctx.Password = password
+//	r, err := ctx.Open("db/password")
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//
+// Nothing is printed: results are returned as readers, byte slices and
+// records, and failures as typed errors such as *ArmorError,
+// *BadSignatureError, *UnknownSignerError, *ConflictError and *FileError.
+// Progress and warnings are reported through the optional Progress and Warn
+// callbacks of the context.
+package store
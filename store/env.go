@@ -0,0 +1,135 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// EnvVar is an environment variable read from a KEY=value secret.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// EnvSyntaxError is returned for a line of a KEY=value secret that cannot be
+// parsed. It does not include the line, which may hold a secret.
+type EnvSyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e *EnvSyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Msg)
+}
+
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ParseEnv parses KEY=value lines, in the format of dotenv files: blank lines
+// and lines starting with # are skipped, a leading "export " is allowed,
+// values may be single quoted, taken literally, or double quoted, where \n,
+// \t, \", \\ and \$ are unescaped. A # preceded by a space starts a comment
+// after an unquoted value.
+func ParseEnv(r io.Reader) ([]EnvVar, error) {
+	var vars []EnvVar
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			return nil, &EnvSyntaxError{lineNo, "missing ="}
+		}
+		name := strings.TrimSpace(line[:i])
+		if !envNameRegexp.MatchString(name) {
+			return nil, &EnvSyntaxError{lineNo, fmt.Sprintf("invalid variable name %q", name)}
+		}
+		value, err := parseEnvValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, &EnvSyntaxError{lineNo, err.Error()}
+		}
+		vars = append(vars, EnvVar{name, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+func parseEnvValue(s string) (string, error) {
+	var value, rest string
+	switch {
+	case strings.HasPrefix(s, "'"):
+		end := strings.Index(s[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated single quoted value")
+		}
+		value, rest = s[1:end+1], s[end+2:]
+	case strings.HasPrefix(s, `"`):
+		var b bytes.Buffer
+		i := 1
+		for ; i < len(s) && s[i] != '"'; i++ {
+			if s[i] != '\\' || i+1 == len(s) {
+				b.WriteByte(s[i])
+				continue
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\', '$':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		}
+		if i == len(s) {
+			return "", fmt.Errorf("unterminated double quoted value")
+		}
+		value, rest = b.String(), s[i+1:]
+	default:
+		if i := strings.Index(s, " #"); i >= 0 {
+			s = s[:i]
+		}
+		return strings.TrimSpace(s), nil
+	}
+
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected text after the closing quote")
+	}
+	return value, nil
+}
+
+// ReadEnv decrypts the named secret and parses it with ParseEnv.
+func (ctx *SecureContext) ReadEnv(name string) ([]EnvVar, error) {
+	r, err := ctx.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ParseEnv(r)
+}
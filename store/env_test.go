@@ -0,0 +1,100 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEnvFile exercises the syntax of env files, with the variables it sets
+// in testEnvVars.
+const testEnvFile = `# database
+export DB_USER=admin
+ DB_HOST = db.example.com 
+EMPTY=
+PLAIN=x # comment
+HASH=x#not-a-comment
+EQUALS=b=c
+SINGLE='$HOME \n "x"'
+DOUBLE="line1\nline2\t\"q\" \\ \$HOME"
+UNKNOWN_ESCAPE="\x"
+QUOTED_HASH="# kept" # comment
+EMPTY_QUOTES='' # comment
+CRLF=1` + "\r\n"
+
+var testEnvVars = []EnvVar{
+	{"DB_USER", "admin"},
+	{"DB_HOST", "db.example.com"},
+	{"EMPTY", ""},
+	{"PLAIN", "x"},
+	{"HASH", "x#not-a-comment"},
+	{"EQUALS", "b=c"},
+	{"SINGLE", `$HOME \n "x"`},
+	{"DOUBLE", "line1\nline2\t\"q\" \\ $HOME"},
+	{"UNKNOWN_ESCAPE", `\x`},
+	{"QUOTED_HASH", "# kept"},
+	{"EMPTY_QUOTES", ""},
+	{"CRLF", "1"},
+}
+
+func TestParseEnv(t *testing.T) {
+	got, err := ParseEnv(strings.NewReader(testEnvFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testEnvVars) {
+		t.Errorf("got %q\nwant %q", got, testEnvVars)
+	}
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	// Inputs mapped to the line of their error.
+	bad := map[string]int{
+		"A=1\nno equals sign": 2,
+		"1A=x":                1,
+		"A-B=x":               1,
+		"=x":                  1,
+		"A='secret":           1,
+		"A=1\n\nB=\"secret":   3,
+		`A="secret\"`:         1,
+		"A='secret' trailing": 1,
+	}
+	for input, line := range bad {
+		_, err := ParseEnv(strings.NewReader(input))
+		syntaxErr, ok := err.(*EnvSyntaxError)
+		switch {
+		case !ok:
+			t.Errorf("%q: got %v, want an *EnvSyntaxError", input, err)
+		case syntaxErr.Line != line:
+			t.Errorf("%q: got line %d, want %d", input, syntaxErr.Line, line)
+		case strings.Contains(err.Error(), "secret"):
+			// Errors end up in logs: they must not leak the value.
+			t.Errorf("%q: error %q holds the value", input, err)
+		}
+	}
+}
+
+func TestReadEnv(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "prod.env", testEnvFile)
+	got, err := ctx.ReadEnv("prod.env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, testEnvVars) {
+		t.Errorf("got %q\nwant %q", got, testEnvVars)
+	}
+}
@@ -0,0 +1,377 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// CredentialFileMode is the mode of the files written by WriteCredentialDir.
+const CredentialFileMode os.FileMode = 0400
+
+// Entry is a key and its value, exported from a directory of secrets or
+// from the fields of a structured secret.
+type Entry struct {
+	Key   string
+	Value []byte
+}
+
+// ReadEntries decrypts the entries of name. When name is a directory of the
+// files directory, every secret directly in it is an entry keyed by its base
+// name. Otherwise name is a structured secret and every value is an entry,
+// keyed by the names of its nested fields separated by dots.
+func (ctx *SecureContext) ReadEntries(name string) ([]Entry, error) {
+	dirPath := path.Join(ctx.FilesPath(), name)
+	if fi, err := os.Stat(dirPath); err != nil || !fi.IsDir() {
+		fields, err := ctx.ReadFields(name)
+		if err != nil {
+			return nil, err
+		}
+		return fieldEntries(nil, "", fields), nil
+	}
+
+	fis, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for _, fi := range fis {
+		if fi.IsDir() || path.Ext(fi.Name()) != ".gpg" {
+			continue
+		}
+		plaintext, err := ctx.ReadSecret(path.Join(dirPath, fi.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", path.Join(name, fi.Name()), err)
+		}
+		entries = append(entries, Entry{strings.TrimSuffix(fi.Name(), ".gpg"), plaintext})
+	}
+	return entries, nil
+}
+
+func fieldEntries(entries []Entry, prefix string, fields Fields) []Entry {
+	for _, field := range fields {
+		if field.Fields != nil {
+			entries = fieldEntries(entries, prefix+field.Name+".", field.Fields)
+		} else {
+			entries = append(entries, Entry{prefix + field.Name, []byte(field.Value)})
+		}
+	}
+	return entries
+}
+
+// K8sSecret is a Kubernetes v1 Secret of type Opaque.
+type K8sSecret struct {
+	Name      string
+	Namespace string
+	Labels    map[string]string
+	Data      []Entry
+}
+
+// K8sNameError is returned for a name or namespace Kubernetes would refuse.
+type K8sNameError struct {
+	// Field is "name" or "namespace".
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *K8sNameError) Error() string {
+	return fmt.Sprintf("invalid %v %q: %v", e.Field, e.Value, e.Err)
+}
+
+// Validate fails unless the name of s is a DNS-1123 subdomain and its
+// namespace, if any, a DNS-1123 label, as Kubernetes requires.
+func (s *K8sSecret) Validate() error {
+	if err := checkDNSSubdomain(s.Name); err != nil {
+		return &K8sNameError{"name", s.Name, err}
+	}
+	if s.Namespace == "" {
+		return nil
+	}
+	if err := checkDNSLabel(s.Namespace, 63); err != nil {
+		return &K8sNameError{"namespace", s.Namespace, err}
+	}
+	return nil
+}
+
+// checkDNSSubdomain fails unless name is at most 253 characters of DNS
+// labels separated by dots.
+func checkDNSSubdomain(name string) error {
+	if len(name) > 253 {
+		return errors.New("longer than 253 characters")
+	}
+	for _, label := range strings.Split(name, ".") {
+		if err := checkDNSLabel(label, 253); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkDNSLabel fails unless label is at most max lowercase letters, digits
+// and '-', starting and ending with a letter or digit.
+func checkDNSLabel(label string, max int) error {
+	if len(label) > max {
+		return fmt.Errorf("longer than %d characters", max)
+	}
+	for i := 0; i < len(label); i++ {
+		if c := label[i]; !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return fmt.Errorf("%q is not allowed, only lowercase letters, digits and '-'", c)
+		}
+	}
+	if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+		return errors.New("must start and end with a lowercase letter or digit")
+	}
+	return nil
+}
+
+// validKey reports whether key is allowed in the data of a Secret and as a
+// file name.
+func validKey(key string) bool {
+	if key == "" || key == "." || key == ".." {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-._", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkKeys fails unless every key is valid and unique.
+func checkKeys(entries []Entry) error {
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		if !validKey(entry.Key) {
+			return fmt.Errorf("invalid key %q", entry.Key)
+		}
+		if seen[entry.Key] {
+			return fmt.Errorf("duplicate key %q", entry.Key)
+		}
+		seen[entry.Key] = true
+	}
+	return nil
+}
+
+// Encode writes s as a YAML manifest, with the values of its data base64
+// encoded. Strings are written as JSON strings, which YAML reads unchanged.
+func (s *K8sSecret) Encode(w io.Writer) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := checkKeys(s.Data); err != nil {
+		return err
+	}
+
+	quote := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "apiVersion: v1\nkind: Secret\nmetadata:\n  name: %v\n", quote(s.Name))
+	if s.Namespace != "" {
+		fmt.Fprintf(bw, "  namespace: %v\n", quote(s.Namespace))
+	}
+	if len(s.Labels) > 0 {
+		keys := make([]string, 0, len(s.Labels))
+		for key := range s.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		fmt.Fprintf(bw, "  labels:\n")
+		for _, key := range keys {
+			fmt.Fprintf(bw, "    %v: %v\n", quote(key), quote(s.Labels[key]))
+		}
+	}
+	fmt.Fprintf(bw, "type: Opaque\n")
+	if len(s.Data) == 0 {
+		fmt.Fprintf(bw, "data: {}\n")
+	} else {
+		fmt.Fprintf(bw, "data:\n")
+		for _, entry := range s.Data {
+			fmt.Fprintf(bw, "  %v: %v\n", quote(entry.Key), quote(base64.StdEncoding.EncodeToString(entry.Value)))
+		}
+	}
+	return bw.Flush()
+}
+
+// EnvName returns key as an environment variable name: characters other than
+// letters, digits and underscores, such as the dots of nested fields, are
+// replaced by underscores and a leading digit is prefixed with one.
+func EnvName(key string) string {
+	name := []rune(key)
+	for i, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || name[0] >= '0' && name[0] <= '9' {
+		return "_" + string(name)
+	}
+	return string(name)
+}
+
+// envEntries returns the entries named by EnvName, failing on names that
+// collide and on values that cannot be held by a variable.
+func envEntries(entries []Entry) ([]EnvVar, error) {
+	vars := make([]EnvVar, 0, len(entries))
+	keys := map[string]string{}
+	for _, entry := range entries {
+		name := EnvName(entry.Key)
+		if key, ok := keys[name]; ok {
+			return nil, fmt.Errorf("keys %q and %q are both variable %v", key, entry.Key, name)
+		}
+		keys[name] = entry.Key
+		if bytes.IndexByte(entry.Value, 0) >= 0 {
+			return nil, fmt.Errorf("value of %q contains a NUL byte", entry.Key)
+		}
+		vars = append(vars, EnvVar{name, string(entry.Value)})
+	}
+	return vars, nil
+}
+
+// EncodeEnv writes entries as KEY=value lines that ParseEnv reads back
+// unchanged, double quoting the values that need it.
+func EncodeEnv(w io.Writer, entries []Entry) error {
+	vars, err := envEntries(entries)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, v := range vars {
+		fmt.Fprintf(bw, "%v=%v\n", v.Name, quoteFieldValue(v.Value))
+	}
+	return bw.Flush()
+}
+
+// EncodeShell writes entries as export KEY='value' lines for sh(1), every
+// value single quoted so that nothing in it is expanded.
+func EncodeShell(w io.Writer, entries []Entry) error {
+	vars, err := envEntries(entries)
+	if err != nil {
+		return err
+	}
+	bw := bufio.NewWriter(w)
+	for _, v := range vars {
+		fmt.Fprintf(bw, "export %v='%v'\n", v.Name, strings.Replace(v.Value, "'", `'\''`, -1))
+	}
+	return bw.Flush()
+}
+
+// WorldReadableError is returned when secrets would be exported to a file or
+// directory that others can read.
+type WorldReadableError struct {
+	Path string
+	Mode os.FileMode
+}
+
+func (e *WorldReadableError) Error() string {
+	return fmt.Sprintf("refusing to export to %v, which is readable by others (mode %#o)", e.Path, e.Mode)
+}
+
+// CheckOutputPath returns a *WorldReadableError when filePath exists and is
+// readable by others. When it does not exist yet, the closest directory it
+// would be created in is checked like a plaintext directory by
+// checkOutputDir.
+func CheckOutputPath(filePath string) error {
+	fi, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return checkOutputDir(filepath.Dir(filePath))
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0004 != 0 {
+		return &WorldReadableError{filePath, fi.Mode().Perm()}
+	}
+	return nil
+}
+
+// checkOutputDir returns an *UnsafeDirError unless the closest existing one
+// of dir and its parents belongs to the user and cannot be written by group
+// or others, who could otherwise replace what is exported into it.
+func checkOutputDir(dir string) error {
+	for {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			break
+		}
+		dir = filepath.Dir(dir)
+	}
+	fi, err := checkDirOwner(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0022 != 0 {
+		return &UnsafeDirError{dir, fmt.Sprintf("it is writable by group or others (mode %#o)", fi.Mode().Perm())}
+	}
+	return nil
+}
+
+// WriteCredentialDir writes every entry to a file of dir named by its key,
+// with mode 0400, as read by systemd LoadCredential= or Docker secrets. dir is
+// created with mode 0700 if needed and refused if others can read it, or, when
+// it does not exist yet, if CheckOutputPath refuses where it is created. The
+// directory belongs to the export: once the entries are written, any other
+// file in it, such as the key of a field since removed, is deleted.
+// Subdirectories are left alone.
+func WriteCredentialDir(dir string, entries []Entry) error {
+	if err := checkKeys(entries); err != nil {
+		return err
+	}
+
+	if err := CheckOutputPath(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, PlaintextDirMode); err != nil {
+		return err
+	}
+	keys := map[string]bool{}
+	for _, entry := range entries {
+		value := entry.Value
+		err := WriteFileAtomic(filepath.Join(dir, entry.Key), CredentialFileMode, func(w io.Writer) error {
+			_, err := w.Write(value)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		keys[entry.Key] = true
+	}
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.IsDir() || keys[fi.Name()] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestK8sSecretEncode(t *testing.T) {
+	secret := &K8sSecret{
+		Name:      "web.creds",
+		Namespace: "prod",
+		Labels:    map[string]string{"tier": "front", "app": "web"},
+		Data:      []Entry{{"db.pass", []byte("hunter2")}, {"empty", nil}},
+	}
+	var b bytes.Buffer
+	if err := secret.Encode(&b); err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: v1
+kind: Secret
+metadata:
+  name: "web.creds"
+  namespace: "prod"
+  labels:
+    "app": "web"
+    "tier": "front"
+type: Opaque
+data:
+  "db.pass": "aHVudGVyMg=="
+  "empty": ""
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestK8sSecretValidate(t *testing.T) {
+	valid := func(name, namespace string) error {
+		return (&K8sSecret{Name: name, Namespace: namespace}).Validate()
+	}
+	for _, name := range []string{"a", "web-1", "web.creds", "0", strings.Repeat("a", 63) + "." + strings.Repeat("b", 189)} {
+		if err := valid(name, ""); err != nil {
+			t.Errorf("name %q refused: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Web", "web_creds", "-web", "web-", "web..creds", ".web", "web/x", strings.Repeat("a", 254)} {
+		if err := valid(name, ""); err == nil || !strings.HasPrefix(err.Error(), "invalid name ") {
+			t.Errorf("name %q: got %v, want invalid name", name, err)
+		}
+	}
+	if err := valid("web", "prod-1"); err != nil {
+		t.Errorf("namespace refused: %v", err)
+	}
+	for _, namespace := range []string{"prod.eu", "Prod", strings.Repeat("a", 64)} {
+		if err := valid("web", namespace); err == nil || !strings.HasPrefix(err.Error(), "invalid namespace ") {
+			t.Errorf("namespace %q: got %v, want invalid namespace", namespace, err)
+		}
+	}
+
+	// Nothing is written for an invalid Secret.
+	var b bytes.Buffer
+	if err := (&K8sSecret{Name: "Web"}).Encode(&b); err == nil || b.Len() != 0 {
+		t.Errorf("got %v, wrote %q", err, b.String())
+	}
+}
+
+// exportEntries holds values that need quoting, and keys that are not
+// variable names.
+var exportEntries = []Entry{
+	{"plain", []byte("value")},
+	{"db.pass", []byte("it's $HOME `id` \"q\"")},
+	{"2fa", []byte("line1\nline2")},
+	{"empty", nil},
+	{"back\\slash", []byte(`a\b`)},
+}
+
+func TestEncodeShell(t *testing.T) {
+	var b bytes.Buffer
+	if err := EncodeShell(&b, exportEntries); err != nil {
+		t.Fatal(err)
+	}
+	want := `export plain='value'
+export db_pass='it'\''s $HOME ` + "`id`" + ` "q"'
+export _2fa='line1
+line2'
+export empty=''
+export back_slash='a\b'
+`
+	if b.String() != want {
+		t.Fatalf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no sh(1)")
+	}
+	// sh must read back every value unchanged.
+	for _, entry := range exportEntries {
+		out, err := exec.Command(sh, "-c", b.String()+`printf %s "$`+EnvName(entry.Key)+`"`).Output()
+		if err != nil {
+			t.Fatalf("sh: %v", err)
+		}
+		if string(out) != string(entry.Value) {
+			t.Errorf("%v: sh read %q, want %q", entry.Key, out, entry.Value)
+		}
+	}
+}
+
+func TestEncodeEnv(t *testing.T) {
+	var b bytes.Buffer
+	if err := EncodeEnv(&b, exportEntries); err != nil {
+		t.Fatal(err)
+	}
+	vars, err := ParseEnv(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != len(exportEntries) {
+		t.Fatalf("read back %q, want %d variables", vars, len(exportEntries))
+	}
+	for i, v := range vars {
+		if entry := exportEntries[i]; v.Name != EnvName(entry.Key) || v.Value != string(entry.Value) {
+			t.Errorf("ParseEnv read back %q, want %v=%q", v, EnvName(entry.Key), entry.Value)
+		}
+	}
+}
+
+func TestEncodeVariableErrors(t *testing.T) {
+	encoders := map[string]func(io.Writer, []Entry) error{"env": EncodeEnv, "shell": EncodeShell}
+	check := func(entries []Entry, want string) {
+		t.Helper()
+		for format, encode := range encoders {
+			var b bytes.Buffer
+			err := encode(&b, entries)
+			if err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("%v: got %v, want an error containing %q", format, err, want)
+			}
+			if b.Len() != 0 {
+				t.Errorf("%v: wrote %q before failing", format, b.String())
+			}
+		}
+	}
+	check([]Entry{{"a", []byte("x\x00y")}}, "NUL byte")
+	check([]Entry{{"a.b", nil}, {"a_b", nil}}, "both variable a_b")
+}
+
+func TestEnvName(t *testing.T) {
+	names := map[string]string{
+		"DB_PASS": "DB_PASS",
+		"db.pass": "db_pass",
+		"api-key": "api_key",
+		"2fa":     "_2fa",
+		"":        "_",
+		"é":       "_",
+	}
+	for key, want := range names {
+		if got := EnvName(key); got != want {
+			t.Errorf("EnvName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// credentialFiles returns the names of the files of dir, with their modes.
+func credentialFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, fi := range fis {
+		files = append(files, fmt.Sprintf("%v %v", fi.Name(), fi.Mode()))
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestWriteCredentialDir(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions")
+	}
+	dir := filepath.Join(t.TempDir(), "web")
+	if err := WriteCredentialDir(dir, []Entry{{"user", []byte("admin")}, {"db.pass", []byte("old")}}); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(dir); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("directory: %v, %v, want mode 0700", fi.Mode(), err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	// db.pass was removed from the secret and api.key added.
+	if err := WriteCredentialDir(dir, []Entry{{"user", []byte("root")}, {"api.key", []byte("k")}}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"api.key -r--------", "sub drwx------", "user -r--------"}
+	if got := credentialFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %q, want %q", got, want)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "user")); string(b) != "root" {
+		t.Errorf("user: got %q, want root", b)
+	}
+
+	if err := os.Chmod(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteCredentialDir(dir, nil); err == nil {
+		t.Error("wrote to a directory others can read")
+	}
+	if got := credentialFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("refused directory changed: %q", got)
+	}
+}
+
+func TestWriteCredentialDirInvalidKey(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "web")
+	for _, key := range []string{"..", "a/b", ""} {
+		if err := WriteCredentialDir(dir, []Entry{{key, nil}}); err == nil {
+			t.Errorf("key %q written", key)
+		}
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("directory created for invalid keys: %v", err)
+	}
+}
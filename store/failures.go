@@ -0,0 +1,157 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"golang.org/x/crypto/openpgp"
+	pgperrors "golang.org/x/crypto/openpgp/errors"
+)
+
+// Causes used to group skipped files in the failure summary.
+const (
+	CauseNoKey        = "no matching key"
+	CauseBadSignature = "bad signature"
+	CauseCorruptArmor = "corrupt armor"
+	CauseConflict     = "conflict"
+	CauseOther        = "other"
+)
+
+var causeOrder = []string{CauseNoKey, CauseBadSignature, CauseCorruptArmor, CauseConflict, CauseOther}
+
+// ArmorError is returned when a secret is not valid ASCII armor.
+type ArmorError struct {
+	Err error
+}
+
+func (e *ArmorError) Error() string {
+	return "corrupt armor: " + e.Err.Error()
+}
+
+// BadSignatureError is returned when a signed secret fails to verify.
+type BadSignatureError struct {
+	Err error
+}
+
+func (e *BadSignatureError) Error() string {
+	return "bad signature: " + e.Err.Error()
+}
+
+// UnknownSignerError is returned when a secret is signed by a key that is
+// not in the key rings, so that its signature cannot be verified.
+type UnknownSignerError struct {
+	KeyId uint64
+}
+
+func (e *UnknownSignerError) Error() string {
+	return fmt.Sprintf("signed by unknown key %016x", e.KeyId)
+}
+
+// FileError is a failure to process a single file.
+type FileError struct {
+	Path string
+	Err  error
+}
+
+func (e *FileError) Error() string {
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Cause classifies the error as one of the Cause constants.
+func (e *FileError) Cause() string {
+	switch err := e.Err.(type) {
+	case *ArmorError:
+		return CauseCorruptArmor
+	case *BadSignatureError, *UnknownSignerError, pgperrors.SignatureError:
+		return CauseBadSignature
+	case *ConflictError:
+		return CauseConflict
+	default:
+		switch err {
+		case pgperrors.ErrKeyIncorrect, errNoPrivateKey:
+			return CauseNoKey
+		case errStale:
+			return CauseConflict
+		}
+	}
+	return CauseOther
+}
+
+// FailureLog collects the per-file errors skipped in keep going mode.
+type FailureLog struct {
+	Errors []*FileError
+}
+
+// Summary writes the skipped files grouped by cause.
+func (f *FailureLog) Summary(w io.Writer) {
+	if len(f.Errors) == 0 {
+		return
+	}
+
+	byCause := map[string][]*FileError{}
+	for _, fileErr := range f.Errors {
+		cause := fileErr.Cause()
+		byCause[cause] = append(byCause[cause], fileErr)
+	}
+
+	fmt.Fprintf(w, "%v file(s) failed:\n", len(f.Errors))
+	for _, cause := range causeOrder {
+		if len(byCause[cause]) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  %v (%v):\n", cause, len(byCause[cause]))
+		for _, fileErr := range byCause[cause] {
+			fmt.Fprintf(w, "    %v\n", fileErr)
+		}
+	}
+}
+
+// fileFailed records err against filePath and returns nil in keep going
+// mode, so that the walk continues. Otherwise it returns err as a FileError.
+func (ctx *SecureContext) fileFailed(filePath string, err error) error {
+	if err == nil {
+		return nil
+	}
+	fileErr := &FileError{filePath, err}
+	if ctx.Failures == nil {
+		return fileErr
+	}
+	ctx.Failures.Errors = append(ctx.Failures.Errors, fileErr)
+	return nil
+}
+
+// CheckSignature returns the result of verifying a signed message. It must
+// be called after md.UnverifiedBody has been read to EOF. Unsigned messages
+// are not checked. A message signed by a key in neither key ring cannot be
+// verified: that is an *UnknownSignerError when the project has a
+// writers.conf, and is let through otherwise.
+func (ctx *SecureContext) CheckSignature(md *openpgp.MessageDetails) error {
+	if !md.IsSigned {
+		return nil
+	}
+	if md.SignedBy == nil {
+		if _, err := os.Stat(path.Join(ctx.DirectoryRoot, WritersFileName)); err != nil {
+			return nil
+		}
+		return &UnknownSignerError{md.SignedByKeyId}
+	}
+	if md.SignatureError != nil {
+		return &BadSignatureError{md.SignatureError}
+	}
+	return nil
+}
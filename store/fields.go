@@ -0,0 +1,287 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrNotStructured is returned when the fields of a free-form secret are
+// asked for.
+var ErrNotStructured = errors.New("not a structured secret")
+
+// Field is an entry of a structured secret: either a value or, for a block
+// of fields such as an account, the nested Fields.
+type Field struct {
+	Name   string
+	Value  string
+	Fields Fields
+}
+
+// Fields are the entries of a structured secret, in the order of the secret.
+type Fields []*Field
+
+// FieldError is returned when a structured secret has no field at Path.
+type FieldError struct {
+	Path string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("no field %q", e.Path)
+}
+
+// ParseFields parses a structured secret, which is one of:
+//
+//   - a JSON object, arrays being fields named by their index;
+//   - key: value lines, in blocks nested by indentation like YAML mappings;
+//   - KEY=value lines, as read by ParseEnv.
+//
+// Anything else is free-form and returns ErrNotStructured.
+func ParseFields(b []byte) (Fields, error) {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("{")) {
+		return parseJSONFields(b)
+	}
+	if vars, err := ParseEnv(bytes.NewReader(b)); err == nil && len(vars) > 0 {
+		var fields Fields
+		for _, v := range vars {
+			fields = append(fields, &Field{Name: v.Name, Value: v.Value})
+		}
+		return fields, nil
+	}
+	return parseBlockFields(b)
+}
+
+// parseBlockFields parses key: value lines. A key without a value opens a
+// block of the lines indented deeper that follow it.
+func parseBlockFields(b []byte) (Fields, error) {
+	type block struct {
+		indent int
+		fields *Fields
+	}
+	var root Fields
+	stack := []block{{0, &root}}
+	openerIndent := 0
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		content := strings.TrimLeft(line, " ")
+		if content == "" || strings.HasPrefix(content, "#") {
+			continue
+		}
+		indent := len(line) - len(content)
+
+		var name, value string
+		if strings.HasSuffix(content, ":") {
+			name = content[:len(content)-1]
+		} else if i := strings.Index(content, ": "); i >= 0 {
+			name = content[:i]
+			var err error
+			if value, err = parseEnvValue(strings.TrimSpace(content[i+2:])); err != nil {
+				return nil, ErrNotStructured
+			}
+		}
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, "\t#") {
+			return nil, ErrNotStructured
+		}
+
+		if top := &stack[len(stack)-1]; top.indent < 0 {
+			if indent > openerIndent {
+				top.indent = indent
+			} else {
+				stack = stack[:len(stack)-1]
+			}
+		}
+		for indent < stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		top := stack[len(stack)-1]
+		if indent != top.indent {
+			return nil, ErrNotStructured
+		}
+
+		field := &Field{Name: name, Value: value}
+		*top.fields = append(*top.fields, field)
+		if strings.HasSuffix(content, ":") {
+			stack = append(stack, block{-1, &field.Fields})
+			openerIndent = indent
+		}
+	}
+	if len(root) == 0 {
+		return nil, ErrNotStructured
+	}
+	return root, nil
+}
+
+func parseJSONFields(b []byte) (Fields, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	field := &Field{}
+	if err := parseJSONValue(dec, field); err != nil {
+		return nil, err
+	}
+	return field.Fields, nil
+}
+
+// parseJSONValue reads the next JSON value of dec into field.
+func parseJSONValue(dec *json.Decoder, field *Field) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token := token.(type) {
+	case json.Delim:
+		for i := 0; dec.More(); i++ {
+			child := &Field{Name: strconv.Itoa(i)}
+			if token == '{' {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				child.Name = key.(string)
+			}
+			if err := parseJSONValue(dec, child); err != nil {
+				return err
+			}
+			field.Fields = append(field.Fields, child)
+		}
+		_, err := dec.Token()
+		return err
+	case string:
+		field.Value = token
+	case json.Number:
+		field.Value = token.String()
+	case bool:
+		field.Value = strconv.FormatBool(token)
+	}
+	return nil
+}
+
+// Lookup returns the field at path, the names of nested fields separated by
+// dots, such as "accountA.password". A backslash escapes the character after
+// it: hosts.db\.example\.com is the field db.example.com of hosts.
+func (f Fields) Lookup(path string) (*Field, error) {
+	fields := f
+	var found *Field
+	for _, name := range splitFieldPath(path) {
+		found = nil
+		for _, field := range fields {
+			if field.Name == name {
+				found = field
+				break
+			}
+		}
+		if found == nil {
+			return nil, &FieldError{path}
+		}
+		fields = found.Fields
+	}
+	return found, nil
+}
+
+// splitFieldPath splits path into field names at the dots that are not
+// escaped with a backslash, removing the escapes.
+func splitFieldPath(path string) []string {
+	var names []string
+	var name strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path):
+			i++
+			name.WriteByte(path[i])
+		case c == '.':
+			names = append(names, name.String())
+			name.Reset()
+		default:
+			name.WriteByte(c)
+		}
+	}
+	return append(names, name.String())
+}
+
+// String formats the fields as key: value lines.
+func (f Fields) String() string {
+	var b bytes.Buffer
+	f.format(&b, "")
+	return b.String()
+}
+
+func (f Fields) format(b *bytes.Buffer, indent string) {
+	for _, field := range f {
+		if field.Fields != nil {
+			fmt.Fprintf(b, "%v%v:\n", indent, field.Name)
+			field.Fields.format(b, indent+"  ")
+		} else {
+			fmt.Fprintf(b, "%v%v: %v\n", indent, field.Name, quoteFieldValue(field.Value))
+		}
+	}
+}
+
+// quoteFieldValue double quotes values that would not read back unchanged.
+func quoteFieldValue(value string) string {
+	if value == "" || strings.TrimSpace(value) != value || strings.ContainsAny(value, "\"'#\\\n\t$") {
+		r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "$", `\$`)
+		return `"` + r.Replace(value) + `"`
+	}
+	return value
+}
+
+// ReadFields decrypts the named secret and parses it with ParseFields.
+func (ctx *SecureContext) ReadFields(name string) (Fields, error) {
+	r, err := ctx.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFields(plaintext)
+}
+
+// FindSecret splits ref, the slash separated path of a secret without the
+// files directory such as "project1/logins", into the directory of its
+// project and the name of the secret. The longest leading directory whose
+// files directory holds the secret is the project. ref may also name a
+// directory of secrets, as read by ReadEntries.
+func FindSecret(ref string) (dir, name string, err error) {
+	parts := strings.Split(path.Clean(filepath.ToSlash(ref)), "/")
+	for i := len(parts) - 1; i >= 0; i-- {
+		dir = filepath.FromSlash(strings.Join(parts[:i], "/"))
+		if dir == "" && i == 1 {
+			dir = string(filepath.Separator)
+		} else if dir == "" {
+			dir = "."
+		}
+		name = strings.Join(parts[i:], "/")
+		ctx := &SecureContext{DirectoryRoot: dir}
+		if fi, err := os.Stat(ctx.SecretPath(name)); err == nil && !fi.IsDir() {
+			return dir, name, nil
+		}
+		if fi, err := os.Stat(path.Join(ctx.FilesPath(), name)); err == nil && fi.IsDir() {
+			return dir, name, nil
+		}
+	}
+	return "", "", &os.PathError{Op: "find secret", Path: ref, Err: os.ErrNotExist}
+}
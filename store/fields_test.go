@@ -0,0 +1,100 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+// lookupAll returns the values of paths in the fields of secret, with "!"
+// for a path that is not found.
+func lookupAll(t *testing.T, secret string, paths ...string) []string {
+	t.Helper()
+	fields, err := ParseFields([]byte(secret))
+	if err != nil {
+		t.Fatalf("%q: %v", secret, err)
+	}
+	var values []string
+	for _, path := range paths {
+		field, err := fields.Lookup(path)
+		if err != nil {
+			if _, ok := err.(*FieldError); !ok {
+				t.Errorf("%v: got %v, want a *FieldError", path, err)
+			}
+			values = append(values, "!")
+			continue
+		}
+		values = append(values, field.Value)
+	}
+	return values
+}
+
+func TestLookup(t *testing.T) {
+	blocks := `accountA:
+  username: alice
+  password: "s3cr#t"
+accountB:
+  username: bob
+`
+	got := lookupAll(t, blocks, "accountA.password", "accountB.username", "accountB.password", "accountA.username.x")
+	if want := []string{"s3cr#t", "bob", "!", "!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("blocks: got %q, want %q", got, want)
+	}
+
+	jsonSecret := `{"servers": [{"host": "a"}, {"host": "b", "port": 22}], "on": true}`
+	got = lookupAll(t, jsonSecret, "servers.1.host", "servers.1.port", "on", "servers.2")
+	if want := []string{"b", "22", "true", "!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON: got %q, want %q", got, want)
+	}
+
+	got = lookupAll(t, "DB_USER=admin\nDB_PASS='x y'\n", "DB_PASS", "DB_USER.x")
+	if want := []string{"x y", "!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("env: got %q, want %q", got, want)
+	}
+}
+
+func TestLookupEscapedDots(t *testing.T) {
+	secret := `{"hosts": {"db.example.com": {"password": "p1"}, "db": {"example": "p2"}}, "a\\b": "p3"}`
+	got := lookupAll(t, secret,
+		`hosts.db\.example\.com.password`,
+		`hosts.db.example`,
+		`a\\b`,
+		`hosts.db.example.com`,
+	)
+	if want := []string{"p1", "p2", "p3", "!"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	for path, want := range map[string][]string{
+		"a":         {"a"},
+		"a.b":       {"a", "b"},
+		`a\.b.c`:    {"a.b", "c"},
+		`a\\.b`:     {`a\`, "b"},
+		`trailing\`: {`trailing\`},
+		"a..b":      {"a", "", "b"},
+	} {
+		if got := splitFieldPath(path); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: got %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestParseFieldsFreeForm(t *testing.T) {
+	if _, err := ParseFields([]byte("just a password\n")); err != ErrNotStructured {
+		t.Errorf("got %v, want ErrNotStructured", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"path"
+	"strings"
+)
+
+// SecretFilter restricts WalkSecrets to a subset of the secrets. Patterns use
+// path.Match syntax and are matched against secret names such as
+// "db/prod/password".
+type SecretFilter struct {
+	// Prefix selects a secret when it, or one of its parent directories,
+	// matches. "db/prod" and "db/prod/*" both select "db/prod/password".
+	Prefix string
+	// Include selects only secrets matching at least one pattern.
+	Include []string
+	// Exclude drops secrets matching any pattern.
+	Exclude []string
+}
+
+// Validate checks that every pattern is well formed.
+func (f *SecretFilter) Validate() error {
+	patterns := append([]string{f.Prefix}, f.Include...)
+	for _, pattern := range append(patterns, f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Match reports whether the secret called name is selected by the filter.
+func (f *SecretFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Prefix != "" && !matchPrefix(strings.Trim(f.Prefix, "/"), name) {
+		return false
+	}
+	if len(f.Include) > 0 && !matchAny(f.Include, name) {
+		return false
+	}
+	return !matchAny(f.Exclude, name)
+}
+
+// matchPrefix matches pattern against name and each of its parent
+// directories.
+func matchPrefix(pattern, name string) bool {
+	for {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		i := strings.LastIndex(name, "/")
+		if i < 0 {
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// matchAny reports whether name matches one of patterns. Patterns without a
+// slash are also matched against the base name.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		if !strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(pattern, path.Base(name)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
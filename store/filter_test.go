@@ -0,0 +1,111 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSecretFilterMatch(t *testing.T) {
+	names := []string{"db/prod/password", "db/staging/password", "web/prod/tls.key", "notes"}
+	selected := func(f *SecretFilter) []string {
+		var matched []string
+		for _, name := range names {
+			if f.Match(name) {
+				matched = append(matched, name)
+			}
+		}
+		return matched
+	}
+
+	if got := selected(nil); !reflect.DeepEqual(got, names) {
+		t.Errorf("nil filter: got %q", got)
+	}
+	if got := selected(&SecretFilter{}); !reflect.DeepEqual(got, names) {
+		t.Errorf("empty filter: got %q", got)
+	}
+
+	// A prefix selects a secret through itself or a parent directory, with
+	// or without slashes around it.
+	for _, prefix := range []string{"db/prod", "/db/prod/", "db/prod/*", "*/prod/password"} {
+		if got := selected(&SecretFilter{Prefix: prefix}); !reflect.DeepEqual(got, []string{"db/prod/password"}) {
+			t.Errorf("prefix %q: got %q", prefix, got)
+		}
+	}
+	if got := selected(&SecretFilter{Prefix: "db"}); len(got) != 2 {
+		t.Errorf("prefix db: got %q", got)
+	}
+	if got := selected(&SecretFilter{Prefix: "d"}); got != nil {
+		t.Errorf("prefix d selects part of a name: got %q", got)
+	}
+
+	// Patterns without a slash also match base names.
+	if got := selected(&SecretFilter{Include: []string{"password"}}); len(got) != 2 {
+		t.Errorf("include password: got %q", got)
+	}
+	if got := selected(&SecretFilter{Include: []string{"*.key", "notes"}}); !reflect.DeepEqual(got, []string{"web/prod/tls.key", "notes"}) {
+		t.Errorf("include *.key and notes: got %q", got)
+	}
+	if got := selected(&SecretFilter{Include: []string{"*/prod/*"}}); !reflect.DeepEqual(got, []string{"db/prod/password", "web/prod/tls.key"}) {
+		t.Errorf("include */prod/*: got %q", got)
+	}
+	if got := selected(&SecretFilter{Include: []string{"prod"}}); got != nil {
+		t.Errorf("include prod matches a directory: got %q", got)
+	}
+
+	// Exclude wins over prefix and include.
+	f := &SecretFilter{Prefix: "db", Include: []string{"*"}, Exclude: []string{"db/staging/*"}}
+	if got := selected(f); !reflect.DeepEqual(got, []string{"db/prod/password"}) {
+		t.Errorf("exclude db/staging/*: got %q", got)
+	}
+	if got := selected(&SecretFilter{Exclude: []string{"password", "notes"}}); !reflect.DeepEqual(got, []string{"web/prod/tls.key"}) {
+		t.Errorf("exclude password and notes: got %q", got)
+	}
+}
+
+func TestSecretFilterValidate(t *testing.T) {
+	if err := (&SecretFilter{Prefix: "db/*", Include: []string{"a?c"}, Exclude: []string{"[ab]*"}}).Validate(); err != nil {
+		t.Errorf("valid filter: %v", err)
+	}
+	for _, f := range []*SecretFilter{
+		{Prefix: "db/["},
+		{Include: []string{"ok", "[z-"}},
+		{Exclude: []string{`\`}},
+	} {
+		if err := f.Validate(); err == nil {
+			t.Errorf("%+v: got no error", f)
+		}
+	}
+}
+
+func TestWalkSecretsFilter(t *testing.T) {
+	ctx := newTestContext(t)
+	for _, name := range []string{"db/prod/password", "db/staging/password", "notes"} {
+		writeTestSecret(t, ctx, name, "secret\n")
+	}
+	ctx.Filter = &SecretFilter{Prefix: "db", Exclude: []string{"*/staging/*"}}
+
+	var walked []string
+	err := ctx.WalkSecrets(func(filePath string) error {
+		walked = append(walked, ctx.SecretName(filePath))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"db/prod/password"}; !reflect.DeepEqual(walked, want) {
+		t.Errorf("got %q, want %q", walked, want)
+	}
+}
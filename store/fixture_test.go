@@ -0,0 +1,95 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The key rings of testdata hold alice, the user of the tests, and bob, a
+// teammate whose private key is only in bob-secring.gpg. Both private keys
+// are protected by testPassword.
+const (
+	testSecureRing = "testdata/secring.gpg"
+	testPublicRing = "testdata/pubring.gpg"
+	testBobRing    = "testdata/bob-secring.gpg"
+	testPassword   = "test"
+)
+
+// newTestContext returns a context, unlocked as alice, for a new project
+// encrypted to alice in a temporary directory.
+func newTestContext(t *testing.T) *SecureContext {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Chmod(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return newTestContextFor(t, dir, testSecureRing)
+}
+
+// newTestContextFor returns a context for the project dir, unlocked with the
+// private keys of secureRing. The access list of a new project holds alice.
+// State files are keyed with a key of the test instead of the user's.
+func newTestContextFor(t *testing.T, dir, secureRing string) *SecureContext {
+	t.Helper()
+	stateKeyPath := DefaultStateKeyPath
+	t.Cleanup(func() { DefaultStateKeyPath = stateKeyPath })
+	DefaultStateKeyPath = filepath.Join(t.TempDir(), "state.key")
+
+	accessList := filepath.Join(dir, AccessListFileName)
+	if _, err := os.Stat(accessList); os.IsNotExist(err) {
+		writeTestFile(t, accessList, "alice@example.com\n")
+	}
+	ctx := NewSecureContext(secureRing, testPublicRing, dir)
+	if err := ctx.ReadKeyRing(); err != nil {
+		t.Fatal(err)
+	}
+	ctx.Password = testPassword
+	return ctx
+}
+
+// writeTestSecret encrypts plaintext to the access list of ctx as the named
+// secret.
+func writeTestSecret(t *testing.T, ctx *SecureContext, name, plaintext string) {
+	t.Helper()
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretPath := ctx.SecretPath(name)
+	if err := os.MkdirAll(filepath.Dir(secretPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = WriteFileAtomic(secretPath, 0644, func(w io.Writer) error {
+		return ctx.Encrypt(w, []byte(plaintext), entityList)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeTestFile writes a file, creating its directory if needed.
+func writeTestFile(t *testing.T, filePath, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(filePath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
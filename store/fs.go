@@ -0,0 +1,187 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FS returns a read-only file system of the decrypted secrets. It mirrors the
+// files directory, with secrets named without their .gpg extension. Secrets
+// are decrypted, and their signature checked, when they are opened, with the
+// keys and password of ctx. Info on the directory entry of a secret decrypts
+// it too, to learn its size.
+func (ctx *SecureContext) FS() fs.FS {
+	return &secretFS{ctx}
+}
+
+type secretFS struct {
+	ctx *SecureContext
+}
+
+func (fsys *secretFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	filePath := filepath.Join(fsys.ctx.FilesPath(), filepath.FromSlash(name))
+
+	if fi, err := os.Stat(filePath); err == nil && fi.IsDir() {
+		return &secretDir{
+			fsys: fsys,
+			name: name,
+			path: filePath,
+			info: newFileInfo(path.Base(name), fi.Size(), fi.Mode(), fi.ModTime()),
+		}, nil
+	}
+
+	fi, err := os.Stat(filePath + ".gpg")
+	if err == nil && fi.IsDir() {
+		err = fs.ErrNotExist
+	}
+	var plaintext []byte
+	if err == nil {
+		plaintext, err = fsys.ctx.ReadSecret(filePath + ".gpg")
+	}
+	if err != nil {
+		if pathErr, ok := err.(*fs.PathError); ok {
+			err = pathErr.Err
+		}
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &secretFile{
+		Reader: bytes.NewReader(plaintext),
+		info:   newFileInfo(path.Base(name), int64(len(plaintext)), PlaintextFileMode, fi.ModTime()),
+	}, nil
+}
+
+// secretFile is an opened secret, held in memory so that it can be seeked.
+type secretFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *secretFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *secretFile) Close() error {
+	return nil
+}
+
+// secretDir is an opened directory of the files directory. Its entries are
+// read on the first call to ReadDir.
+type secretDir struct {
+	fsys    *secretFS
+	name    string
+	path    string
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *secretDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *secretDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *secretDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the subdirectories and secrets of the directory. Other
+// files are left out.
+func (d *secretDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.read = true
+		entries, err := os.ReadDir(d.path)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			fi, err := os.Stat(filepath.Join(d.path, entry.Name()))
+			if err != nil {
+				continue
+			}
+			if fi.IsDir() {
+				info := newFileInfo(entry.Name(), fi.Size(), fi.Mode(), fi.ModTime())
+				d.entries = append(d.entries, fs.FileInfoToDirEntry(info))
+			} else if path.Ext(entry.Name()) == ".gpg" {
+				name := strings.TrimSuffix(entry.Name(), ".gpg")
+				d.entries = append(d.entries, &secretEntry{d.fsys, path.Join(d.name, name)})
+			}
+		}
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// secretEntry is the directory entry of the secret at name.
+type secretEntry struct {
+	fsys *secretFS
+	name string
+}
+
+func (e *secretEntry) Name() string      { return path.Base(e.name) }
+func (e *secretEntry) IsDir() bool       { return false }
+func (e *secretEntry) Type() fs.FileMode { return 0 }
+
+func (e *secretEntry) Info() (fs.FileInfo, error) {
+	f, err := e.fsys.Open(e.name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func newFileInfo(name string, size int64, mode fs.FileMode, modTime time.Time) *fileInfo {
+	return &fileInfo{name: name, size: size, mode: mode, modTime: modTime}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode  { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *fileInfo) IsDir() bool        { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return nil }
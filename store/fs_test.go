@@ -0,0 +1,52 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "logins", "user=admin\n")
+	writeTestSecret(t, ctx, "certs/tls.key", "key\n")
+	writeTestSecret(t, ctx, "certs/old/ca.pem", "ca\n")
+	writeTestSecret(t, ctx, "empty", "")
+	// Files of the files directory that are not secrets are left out.
+	writeTestFile(t, filepath.Join(ctx.FilesPath(), "README"), "not a secret\n")
+	if err := os.Mkdir(filepath.Join(ctx.FilesPath(), "unused"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := ctx.FS()
+	if err := fstest.TestFS(fsys, "logins", "certs/tls.key", "certs/old/ca.pem", "empty", "unused"); err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := fs.ReadFile(fsys, "certs/tls.key"); err != nil || string(b) != "key\n" {
+		t.Errorf("got %q, %v, want the plaintext", b, err)
+	}
+	for _, name := range []string{"README", "logins.gpg", "missing"} {
+		if _, err := fsys.Open(name); !os.IsNotExist(err) {
+			t.Errorf("Open(%q): got %v, want a not exist error", name, err)
+		}
+	}
+	if _, err := fsys.Open("../logins"); err == nil {
+		t.Error("opened a path outside the file system")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// DecryptBytes decrypts an armored secret held in memory. An empty input
+// decrypts to nothing, as git passes an empty file for a missing side.
+func (ctx *SecureContext) DecryptBytes(ciphertext []byte) ([]byte, error) {
+	if len(bytes.TrimSpace(ciphertext)) == 0 {
+		return nil, nil
+	}
+	md, err := ctx.DecryptReader(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.CheckSignature(md); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// MergeRecipients returns the keys a merged secret is encrypted to: the
+// access list of the project containing pathName, relative to the work tree,
+// or, without a path or a project, the recipients of the current version.
+func (ctx *SecureContext) MergeRecipients(pathName, current string) (openpgp.EntityList, error) {
+	if pathName != "" {
+		workTree, _, err := FindGitDir(".")
+		if err != nil {
+			return nil, err
+		}
+		if dir, ok := ProjectOf(workTree, filepath.ToSlash(pathName)); ok {
+			return ctx.ForProject(Project{Dir: filepath.Join(workTree, dir)}).ReadAccessList()
+		}
+	}
+
+	keyIds, err := ReadRecipients(current)
+	if err != nil {
+		return nil, err
+	}
+	var entityList openpgp.EntityList
+	for _, keyId := range keyIds {
+		keys := ctx.PublicRing.KeysById(keyId)
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("recipient %016x not in keyring", keyId)
+		}
+		entityList = append(entityList, keys[0].Entity)
+	}
+	return entityList, nil
+}
@@ -0,0 +1,198 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// FilterName is the value of the filter attribute selecting gosec's clean and
+// smudge filters in .gitattributes.
+const FilterName = "gosec"
+
+// filterStateFileName is the state file, in the git directory, of the files
+// going through the filters. Like the state of a project, it lets clean tell
+// unchanged plaintext without decrypting anything.
+const filterStateFileName = "gosec-filter-state"
+
+var errNoProject = errors.New("not inside a project, no access list to encrypt to")
+
+var errNoPassword = errors.New("password required")
+
+// armorHeader starts every armored message.
+var armorHeader = []byte("-----BEGIN PGP MESSAGE-----")
+
+// IsArmored reports whether b starts like an armored secret.
+func IsArmored(b []byte) bool {
+	return bytes.HasPrefix(b, armorHeader)
+}
+
+// GitFilter implements git's clean and smudge filters for the files of a
+// work tree.
+type GitFilter struct {
+	ctx        *SecureContext
+	workTree   string
+	state      *SyncState
+	agentTried bool
+
+	// Prompt returns the password of the secret keyring. It is called at
+	// most once, the first time something has to be decrypted or encrypted.
+	Prompt func() (string, error)
+}
+
+// NewGitFilter returns a filter for the repository enclosing the current
+// directory. The password is only asked for when something has to be
+// decrypted or encrypted.
+func NewGitFilter() (*GitFilter, error) {
+	workTree, gitDir, err := FindGitDir(".")
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadSyncState(filepath.Join(gitDir, filterStateFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := NewSecureContext(
+		DefaultSecureRingPath,
+		DefaultPublicRingPath,
+		"",
+	)
+	if err := ctx.ReadKeyRing(); err != nil {
+		return nil, err
+	}
+	return &GitFilter{ctx: ctx, workTree: workTree, state: state}, nil
+}
+
+// unlockDecrypt lets ctx decrypt: through the agent when one is running,
+// since git runs filters without a terminal, otherwise as unlock does. If
+// the agent fails, it is given up for the password.
+func (filter *GitFilter) unlockDecrypt(ctx *SecureContext) error {
+	if filter.ctx.Password == "" && !filter.agentTried {
+		filter.agentTried = true
+		if agent, err := DialAgent(AgentSocketPath()); err == nil {
+			filter.ctx.Agent = agent
+		}
+	}
+	if filter.ctx.Agent != nil {
+		ctx.Agent = filter.ctx.Agent
+		ctx.Prompt = func() (string, error) {
+			filter.ctx.Agent = nil
+			if err := filter.unlock(ctx); err != nil {
+				return "", err
+			}
+			return ctx.Password, nil
+		}
+		return nil
+	}
+	return filter.unlock(ctx)
+}
+
+// unlock asks for the password, once, and sets it on ctx. Encrypting needs
+// it even with an agent, which cannot sign.
+func (filter *GitFilter) unlock(ctx *SecureContext) error {
+	if filter.ctx.Password == "" {
+		if filter.Prompt == nil {
+			return errNoPassword
+		}
+		password, err := filter.Prompt()
+		if err != nil {
+			return err
+		}
+		filter.ctx.Password = password
+	}
+	ctx.Password = filter.ctx.Password
+	return nil
+}
+
+// Clean returns the ciphertext to store for the plaintext of the file at rel,
+// relative to the work tree. If the staged ciphertext of the file still holds
+// plaintext and is encrypted to the access list, it is returned unchanged, so
+// that cleaning is deterministic. Input that already is ciphertext is passed
+// through.
+func (filter *GitFilter) Clean(rel string, plaintext []byte) ([]byte, error) {
+	if IsArmored(plaintext) {
+		return plaintext, nil
+	}
+
+	dir, ok := ProjectOf(filter.workTree, filepath.ToSlash(rel))
+	if !ok {
+		return nil, errNoProject
+	}
+	ctx := filter.ctx.ForProject(Project{Dir: filepath.Join(filter.workTree, filepath.FromSlash(dir))})
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		return nil, err
+	}
+
+	previous, ok := gitBlob(filter.workTree, ":"+filepath.ToSlash(rel))
+	if ok && IsArmored(previous) {
+		keyIds, err := readRecipients(bytes.NewReader(previous))
+		current := err == nil && ctx.auditSecret(rel, keyIds, entityList).OK()
+		if current && !filter.state.UpstreamChanged(rel, previous) && !filter.state.LocalChanged(rel, plaintext) {
+			return previous, nil
+		}
+		if current {
+			if err := filter.unlockDecrypt(ctx); err != nil {
+				return nil, err
+			}
+			if upstream, err := ctx.DecryptBytes(previous); err == nil && bytes.Equal(upstream, plaintext) {
+				filter.state.Record(rel, previous, plaintext)
+				return previous, filter.state.Save()
+			}
+		}
+	}
+
+	if err := filter.unlock(ctx); err != nil {
+		return nil, err
+	}
+	var ciphertext bytes.Buffer
+	if err := ctx.Encrypt(&ciphertext, plaintext, entityList); err != nil {
+		return nil, err
+	}
+	filter.state.Record(rel, ciphertext.Bytes(), plaintext)
+	return ciphertext.Bytes(), filter.state.Save()
+}
+
+// Smudge returns the plaintext to check out for the ciphertext of the file at
+// rel. Input that is not ciphertext is passed through.
+func (filter *GitFilter) Smudge(rel string, ciphertext []byte) ([]byte, error) {
+	if !IsArmored(ciphertext) {
+		return ciphertext, nil
+	}
+	if err := filter.unlockDecrypt(filter.ctx); err != nil {
+		return nil, err
+	}
+	plaintext, err := filter.ctx.DecryptBytes(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	filter.state.Record(rel, ciphertext, plaintext)
+	return plaintext, filter.state.Save()
+}
+
+// filterAttribute returns the filter attribute of the file rel, relative to
+// workTree.
+func filterAttribute(workTree, rel string) (string, error) {
+	out, err := git(workTree, "check-attr", "filter", "--", rel)
+	if err != nil {
+		return "", err
+	}
+	// The output is "path: filter: value".
+	line := strings.TrimSpace(string(out))
+	return line[strings.LastIndex(line, ": ")+2:], nil
+}
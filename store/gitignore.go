@@ -0,0 +1,175 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// GitignoreFileName is the .gitignore in the project directory gosec keeps a
+// block of plaintext paths in.
+const GitignoreFileName = ".gitignore"
+
+// The lines delimiting the block gosec manages in .gitignore. Everything
+// outside of them is left alone.
+const (
+	gitignoreBegin = "# BEGIN gosec: decrypted plaintext, managed by gosec, do not edit"
+	gitignoreEnd   = "# END gosec"
+)
+
+// secretNames returns the names of all secrets of the project, ignoring
+// ctx.Filter.
+func (ctx *SecureContext) secretNames() ([]string, error) {
+	var names []string
+	err := filepath.Walk(ctx.FilesPath(), func(filePath string, fi os.FileInfo, err error) error {
+		if os.IsNotExist(err) && filePath == ctx.FilesPath() {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() && filepath.Ext(filePath) == ".gpg" {
+			names = append(names, ctx.SecretName(filePath))
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// escapeGitignore quotes the characters gitignore treats specially.
+func escapeGitignore(name string) string {
+	var buf bytes.Buffer
+	for _, r := range name {
+		if strings.ContainsRune(`\*?[`, r) {
+			buf.WriteByte('\\')
+		}
+		buf.WriteRune(r)
+	}
+	s := buf.String()
+	if strings.HasSuffix(s, " ") {
+		s = s[:len(s)-1] + `\ `
+	}
+	return s
+}
+
+// gitignorePatterns returns the patterns of the managed block: the state
+// file, conflict files and, when the plaintext directory is inside the
+// project, the plaintext path of every secret.
+func (ctx *SecureContext) gitignorePatterns() ([]string, error) {
+	patterns := []string{"/" + StateFileName, "*" + ConflictSuffix}
+
+	root, plain := filepath.Clean(ctx.DirectoryRoot), filepath.Clean(ctx.plaintextDir())
+	if !insideDir(root, plain) {
+		return patterns, nil
+	}
+	rel, err := filepath.Rel(root, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := ctx.secretNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		patterns = append(patterns, "/"+escapeGitignore(path.Join(filepath.ToSlash(rel), name)))
+	}
+	return patterns, nil
+}
+
+// replaceBlock returns contents with the managed block replaced by patterns,
+// or with the block appended if there is none.
+func replaceBlock(contents []byte, patterns []string) []byte {
+	var block bytes.Buffer
+	block.WriteString(gitignoreBegin + "\n")
+	for _, pattern := range patterns {
+		block.WriteString(pattern + "\n")
+	}
+	block.WriteString(gitignoreEnd + "\n")
+
+	s := string(contents)
+	begin := strings.Index(s, gitignoreBegin+"\n")
+	if begin >= 0 {
+		if end := strings.Index(s[begin:], gitignoreEnd+"\n"); end >= 0 {
+			end += begin + len(gitignoreEnd) + 1
+			return []byte(s[:begin] + block.String() + s[end:])
+		}
+	}
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		s += "\n"
+	}
+	return []byte(s + block.String())
+}
+
+// UpdateGitignore rewrites the gosec block of the project's .gitignore so
+// that the state file, conflict files and the plaintext of every secret are
+// ignored by git. The file is only written when the block changed.
+func (ctx *SecureContext) UpdateGitignore() error {
+	patterns, err := ctx.gitignorePatterns()
+	if err != nil {
+		return err
+	}
+
+	filePath := path.Join(ctx.DirectoryRoot, GitignoreFileName)
+	perm := os.FileMode(0644)
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if fi, err := os.Stat(filePath); err == nil {
+		perm = fi.Mode().Perm()
+	}
+
+	updated := replaceBlock(contents, patterns)
+	if bytes.Equal(contents, updated) {
+		return nil
+	}
+	return WriteFileAtomic(filePath, perm, func(w io.Writer) error {
+		_, err := w.Write(updated)
+		return err
+	})
+}
+
+// InitProject creates the files directory and an access list, unless they
+// exist, and the .gitignore block of a project.
+func (ctx *SecureContext) InitProject() error {
+	if err := os.MkdirAll(ctx.FilesPath(), 0700); err != nil {
+		return err
+	}
+
+	accessList := path.Join(ctx.DirectoryRoot, AccessListFileName)
+	fp, err := os.OpenFile(accessList, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err == nil {
+		_, err = fmt.Fprintln(fp, "# Email addresses of the keys secrets are encrypted to, one per line.")
+		if closeErr := fp.Close(); err == nil {
+			err = closeErr
+		}
+	} else if os.IsExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return ctx.UpdateGitignore()
+}
@@ -0,0 +1,53 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestInitProject(t *testing.T) {
+	dir := t.TempDir()
+	ctx := NewSecureContext(testSecureRing, testPublicRing, dir)
+	if err := ctx.InitProject(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(ctx.FilesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0700 && runtime.GOOS != "windows" {
+		t.Errorf("files directory created with mode %v, want 0700", perm)
+	}
+	gitignore, err := ioutil.ReadFile(filepath.Join(dir, GitignoreFileName))
+	if err != nil || !strings.Contains(string(gitignore), gitignoreBegin) {
+		t.Errorf("no gosec block in .gitignore: %q, %v", gitignore, err)
+	}
+
+	// An existing access list is kept.
+	accessList := filepath.Join(dir, AccessListFileName)
+	writeTestFile(t, accessList, "alice@example.com\n")
+	if err := ctx.InitProject(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(accessList); string(b) != "alice@example.com\n" {
+		t.Errorf("access list overwritten with %q", b)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	errNotGitRepository = errors.New("not inside a git repository")
+	errBadGitIndex      = errors.New("malformed git index")
+)
+
+// FindGitDir returns the work tree and git directory of the repository
+// enclosing dir. A .git file, as used by work trees and submodules, is
+// followed to the git directory it names.
+func FindGitDir(dir string) (workTree, gitDir string, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		fi, err := os.Stat(dotGit)
+		if err == nil && fi.IsDir() {
+			return dir, dotGit, nil
+		}
+		if err == nil {
+			b, err := ioutil.ReadFile(dotGit)
+			if err != nil {
+				return "", "", err
+			}
+			line := strings.TrimSpace(string(b))
+			if !strings.HasPrefix(line, "gitdir: ") {
+				return "", "", fmt.Errorf("%v: not a gitdir file", dotGit)
+			}
+			gitDir = strings.TrimPrefix(line, "gitdir: ")
+			if !filepath.IsAbs(gitDir) {
+				gitDir = filepath.Join(dir, gitDir)
+			}
+			return dir, gitDir, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", errNotGitRepository
+		}
+		dir = parent
+	}
+}
+
+// ReadGitIndex returns the slash separated paths, relative to the work tree,
+// of every entry of the git index file at indexPath: all tracked and staged
+// files. Index versions 2 to 4 with SHA-1 object names are supported.
+func ReadGitIndex(indexPath string) ([]string, error) {
+	b, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < 12 || string(b[:4]) != "DIRC" {
+		return nil, errBadGitIndex
+	}
+	version := binary.BigEndian.Uint32(b[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported git index version %d", version)
+	}
+	count := binary.BigEndian.Uint32(b[8:12])
+
+	// Each entry starts with 40 bytes of stat data and mode, a 20 byte object
+	// name and 16 bits of flags.
+	const fixedSize = 40 + 20 + 2
+	names := make([]string, 0, count)
+	previous := ""
+	offset := 12
+	for i := uint32(0); i < count; i++ {
+		start := offset
+		if offset+fixedSize > len(b) {
+			return nil, errBadGitIndex
+		}
+		flags := binary.BigEndian.Uint16(b[offset+60:])
+		offset += fixedSize
+		if flags&0x4000 != 0 {
+			if version < 3 {
+				return nil, errBadGitIndex
+			}
+			offset += 2
+		}
+		if offset > len(b) {
+			return nil, errBadGitIndex
+		}
+
+		var name string
+		if version == 4 {
+			// The name replaces a varint number of trailing bytes of the
+			// previous name with a NUL terminated suffix.
+			strip, n := gitIndexVarint(b[offset:])
+			if n == 0 || strip > uint64(len(previous)) {
+				return nil, errBadGitIndex
+			}
+			offset += n
+			end := bytes.IndexByte(b[offset:], 0)
+			if end < 0 {
+				return nil, errBadGitIndex
+			}
+			name = previous[:len(previous)-int(strip)] + string(b[offset:offset+end])
+			offset += end + 1
+		} else {
+			// The name is NUL terminated and the entry padded with NULs to a
+			// multiple of eight bytes.
+			end := bytes.IndexByte(b[offset:], 0)
+			if end < 0 {
+				return nil, errBadGitIndex
+			}
+			name = string(b[offset : offset+end])
+			offset = start + ((offset + end - start + 8) &^ 7)
+		}
+		names = append(names, name)
+		previous = name
+	}
+	return names, nil
+}
+
+// gitIndexVarint decodes the offset encoded integers of index version 4. It
+// returns the value and the number of bytes read, or 0 bytes on error.
+func gitIndexVarint(b []byte) (uint64, int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	value := uint64(b[0] & 0x7f)
+	n := 1
+	for b[n-1]&0x80 != 0 {
+		if n >= len(b) || n > 9 {
+			return 0, 0
+		}
+		value = ((value + 1) << 7) | uint64(b[n]&0x7f)
+		n++
+	}
+	return value, n
+}
+
+// TrackedPlaintext returns the plaintext paths of the project's secrets that
+// are tracked or staged in the enclosing git repository, read from its index
+// file or from $GIT_INDEX_FILE.
+func (ctx *SecureContext) TrackedPlaintext() ([]string, error) {
+	workTree, gitDir, err := FindGitDir(ctx.DirectoryRoot)
+	if err != nil {
+		return nil, err
+	}
+	indexPath := os.Getenv("GIT_INDEX_FILE")
+	if indexPath == "" {
+		indexPath = filepath.Join(gitDir, "index")
+	}
+
+	entries, err := ReadGitIndex(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", indexPath, err)
+	}
+	tracked := map[string]bool{}
+	for _, entry := range entries {
+		tracked[entry] = true
+	}
+
+	names, err := ctx.secretNames()
+	if err != nil {
+		return nil, err
+	}
+	var found []string
+	for _, name := range names {
+		plainPath := filepath.Join(ctx.plaintextDir(), filepath.FromSlash(name))
+		abs, err := filepath.Abs(plainPath)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(workTree, abs)
+		if err != nil || !insideDir(workTree, abs) {
+			continue
+		}
+		if tracked[filepath.ToSlash(rel)] {
+			found = append(found, plainPath)
+		}
+	}
+	return found, nil
+}
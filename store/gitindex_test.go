@@ -0,0 +1,240 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildGitIndex returns an index file of the given version holding names,
+// encoded the way git writes them. Names starting with + get the extended
+// flags of a skip-worktree entry, without the +.
+func buildGitIndex(version uint32, names ...string) []byte {
+	var b bytes.Buffer
+	b.WriteString("DIRC")
+	binary.Write(&b, binary.BigEndian, version)
+	binary.Write(&b, binary.BigEndian, uint32(len(names)))
+	previous := ""
+	for _, name := range names {
+		extended := strings.HasPrefix(name, "+")
+		name = strings.TrimPrefix(name, "+")
+
+		start := b.Len()
+		b.Write(make([]byte, 40+20))
+		flags := uint16(len(name))
+		if len(name) > 0xfff {
+			flags = 0xfff
+		}
+		if extended {
+			flags |= 0x4000
+		}
+		binary.Write(&b, binary.BigEndian, flags)
+		if extended {
+			// The skip-worktree flag.
+			b.Write([]byte{0x40, 0})
+		}
+		if version == 4 {
+			common := 0
+			for common < len(previous) && common < len(name) && previous[common] == name[common] {
+				common++
+			}
+			b.Write(encodeGitIndexVarint(uint64(len(previous) - common)))
+			b.WriteString(name[common:])
+			b.WriteByte(0)
+		} else {
+			b.WriteString(name)
+			b.Write(make([]byte, 8-(b.Len()-start)%8))
+		}
+		previous = name
+	}
+	return b.Bytes()
+}
+
+// encodeGitIndexVarint is the inverse of gitIndexVarint.
+func encodeGitIndexVarint(value uint64) []byte {
+	b := []byte{byte(value & 0x7f)}
+	for value >>= 7; value != 0; value >>= 7 {
+		value--
+		b = append([]byte{byte(0x80 | value&0x7f)}, b...)
+	}
+	return b
+}
+
+// readTestIndex writes index to a file and reads it back with ReadGitIndex.
+func readTestIndex(t *testing.T, index []byte) ([]string, error) {
+	t.Helper()
+	indexPath := filepath.Join(t.TempDir(), "index")
+	if err := ioutil.WriteFile(indexPath, index, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return ReadGitIndex(indexPath)
+}
+
+func TestReadGitIndex(t *testing.T) {
+	long := "files/" + strings.Repeat("d", 200)
+	names := []string{".gitignore", "files/a.gpg", "files/ab.gpg", long + "/x", long + "/y", "plain/b.txt"}
+
+	for version := uint32(2); version <= 4; version++ {
+		entries := names
+		if version > 2 {
+			entries = append([]string{}, names...)
+			entries[2] = "+" + entries[2]
+		}
+		got, err := readTestIndex(t, buildGitIndex(version, entries...))
+		if err != nil {
+			t.Errorf("version %d: %v", version, err)
+		} else if !reflect.DeepEqual(got, names) {
+			t.Errorf("version %d: got %q, want %q", version, got, names)
+		}
+	}
+
+	// A name filling its entry up to a multiple of eight bytes still gets a
+	// full eight bytes of padding.
+	got, err := readTestIndex(t, buildGitIndex(2, "abcdefgh", "z"))
+	if err != nil || !reflect.DeepEqual(got, []string{"abcdefgh", "z"}) {
+		t.Errorf("got %q, %v, want abcdefgh and z", got, err)
+	}
+
+	if got, err := readTestIndex(t, buildGitIndex(2)); err != nil || len(got) != 0 {
+		t.Errorf("empty index: got %q, %v", got, err)
+	}
+	if got, err := ReadGitIndex(filepath.Join(t.TempDir(), "index")); got != nil || err != nil {
+		t.Errorf("missing index: got %q, %v, want nil, nil", got, err)
+	}
+}
+
+func TestReadGitIndexCorrupt(t *testing.T) {
+	index := buildGitIndex(2, "files/a.gpg", "plain/b.txt")
+	version := func(index []byte, version uint32) []byte {
+		index = append([]byte{}, index...)
+		binary.BigEndian.PutUint32(index[4:8], version)
+		return index
+	}
+	corrupt := map[string][]byte{
+		"bad signature":               append([]byte("DIRX"), index[4:]...),
+		"short header":                []byte("DIRC"),
+		"version 5":                   version(index, 5),
+		"extended flags in version 2": version(buildGitIndex(3, "+a"), 2),
+		"truncated":                   index[:len(index)-10],
+		"version 4 strips too much":   append(buildGitIndex(4, "a")[:12+62], 5, 'b', 0),
+	}
+	for name, index := range corrupt {
+		if got, err := readTestIndex(t, index); err == nil {
+			t.Errorf("%v: got %q, want an error", name, got)
+		}
+	}
+}
+
+func TestGitIndexVarint(t *testing.T) {
+	for _, value := range []uint64{0, 1, 127, 128, 200, 16383, 16511, 1 << 20} {
+		b := encodeGitIndexVarint(value)
+		got, n := gitIndexVarint(append(b, 0xff))
+		if got != value || n != len(b) {
+			t.Errorf("%d: got %d, %d bytes, want %d bytes", value, got, n, len(b))
+		}
+	}
+	if _, n := gitIndexVarint([]byte{0x80}); n != 0 {
+		t.Errorf("truncated varint: got %d bytes, want 0", n)
+	}
+}
+
+// TestTrackedPlaintext checks the indexes of the installed git, in every
+// version it writes.
+func TestTrackedPlaintext(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	if os.Getenv("GIT_INDEX_FILE") != "" {
+		t.Skip("GIT_INDEX_FILE is set")
+	}
+	ctx := newTestContext(t)
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = ctx.DirectoryRoot
+		for _, env := range os.Environ() {
+			if !strings.HasPrefix(env, "GIT_") {
+				cmd.Env = append(cmd.Env, env)
+			}
+		}
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "-q")
+
+	writeTestSecret(t, ctx, "staged", "s\n")
+	writeTestSecret(t, ctx, "dir/ignored", "i\n")
+	writeTestSecret(t, ctx, "untracked", "u\n")
+	writeTestFile(t, filepath.Join(ctx.DirectoryRoot, "staged"), "s\n")
+	writeTestFile(t, filepath.Join(ctx.DirectoryRoot, "dir/ignored"), "i\n")
+	git("add", "staged", "dir/ignored", "files")
+	want := []string{
+		filepath.Join(ctx.DirectoryRoot, "dir", "ignored"),
+		filepath.Join(ctx.DirectoryRoot, "staged"),
+	}
+
+	for _, version := range []string{"2", "3", "4"} {
+		git("update-index", "--index-version", version)
+		got, err := ctx.TrackedPlaintext()
+		if err != nil {
+			t.Fatalf("version %v: %v", version, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("version %v: got %q, want %q", version, got, want)
+		}
+	}
+}
+
+func TestUpdateGitignore(t *testing.T) {
+	ctx := newTestContext(t)
+	gitignore := filepath.Join(ctx.DirectoryRoot, GitignoreFileName)
+	writeTestFile(t, gitignore, "*.o")
+	writeTestSecret(t, ctx, "logins", "l\n")
+	writeTestSecret(t, ctx, "keys/ssh[1]*", "k\n")
+
+	if err := ctx.UpdateGitignore(); err != nil {
+		t.Fatal(err)
+	}
+	want := "*.o\n" + gitignoreBegin + "\n" +
+		"/" + StateFileName + "\n" +
+		"*" + ConflictSuffix + "\n" +
+		"/keys/ssh\\[1]\\*\n" +
+		"/logins\n" +
+		gitignoreEnd + "\n"
+	if b, _ := ioutil.ReadFile(gitignore); string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+
+	// The block is replaced in place, leaving the lines around it alone.
+	writeTestFile(t, gitignore, "top\n"+want+"bottom\n")
+	if err := os.Remove(ctx.SecretPath("logins")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.UpdateGitignore(); err != nil {
+		t.Fatal(err)
+	}
+	want = "top\n" + strings.Replace(want, "/logins\n", "", 1) + "bottom\n"
+	if b, _ := ioutil.ReadFile(gitignore); string(b) != want {
+		t.Errorf("got\n%s\nwant\n%s", b, want)
+	}
+}
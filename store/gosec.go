@@ -0,0 +1,637 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+var DefaultSecureRingPath = "~/.gnupg/secring.gpg"
+var DefaultPublicRingPath = "~/.gnupg/pubring.gpg"
+
+// AccessListFileName lists, in the project directory, the email addresses of
+// the keys secrets are encrypted to.
+const AccessListFileName = "access-list.conf"
+
+// WritersFileName lists, in the project directory, the email addresses of the
+// keys allowed to sign secrets. Without it, the access list is used.
+const WritersFileName = "writers.conf"
+
+var errNoPrivateKey = errors.New("invalid password or no private key")
+
+// Results of encrypting a plaintext file, as reported by EncryptRoot, of
+// removing a secret and of resolving a conflict.
+const (
+	SecretCreated   = "created"
+	SecretUpdated   = "updated"
+	SecretUnchanged = "unchanged"
+	SecretRemoved   = "removed"
+	SecretResolved  = "resolved"
+)
+
+type SecureContext struct {
+	SecureRingPath string
+	PubRingPath    string
+	DirectoryRoot  string
+	Project        string
+	// PlaintextDir, when set, replaces DirectoryRoot as the place decrypted
+	// files are written to and plaintext is encrypted from.
+	PlaintextDir string
+	// privateBase is the per-user directory of the tmpfs target, set by
+	// SetPlaintextDir, that plaintext directories under it are checked from.
+	privateBase string
+	// Extensions overrides the extensions of the plaintext files that
+	// EncryptRoot encrypts.
+	Extensions []string
+
+	PrivateRing openpgp.EntityList
+	PublicRing  openpgp.EntityList
+	Password    string
+
+	SearchRegex *regexp.Regexp
+	Filter      *SecretFilter
+	// Failures, when set, collects per-file errors instead of aborting.
+	Failures *FailureLog
+	// ConflictMode is one of the Conflict constants.
+	ConflictMode string
+	// FollowSymlinks makes EncryptRoot follow symlinks that stay inside the
+	// plaintext directory instead of skipping them.
+	FollowSymlinks bool
+	// Progress, when set, is called with the status and name of every
+	// secret EncryptRoot encrypts or finds unchanged.
+	Progress func(status, name string)
+	// Warn, when set, is called with problems that do not stop an
+	// operation, such as an *InsecureDirError.
+	Warn func(err error)
+	// Agent, when set, decrypts session keys instead of the private keys
+	// unlocked with Password, which is then only needed to sign.
+	Agent KeyAgent
+	// Prompt, when set, is called for Password when the agent fails, so
+	// that decrypting carries on with the private keys.
+	Prompt func() (string, error)
+
+	state  *SyncState
+	signer *openpgp.Entity
+}
+
+func NewSecureContext(secureRingPath, pubRingPath, directoryRoot string) *SecureContext {
+	return &SecureContext{
+		SecureRingPath: secureRingPath,
+		PubRingPath:    pubRingPath,
+		DirectoryRoot:  directoryRoot,
+		ConflictMode:   ConflictRefuse,
+	}
+}
+
+func (ctx *SecureContext) ReadKeyRing() error {
+	secringPath, _ := expandPath(ctx.SecureRingPath)
+	privringFile, err := os.Open(secringPath)
+	if err != nil {
+		return err
+	}
+	defer privringFile.Close()
+
+	ctx.PrivateRing, err = openpgp.ReadKeyRing(privringFile)
+	if err != nil {
+		return err
+	}
+
+	pubringPath, _ := expandPath(ctx.PubRingPath)
+	pubringFile, err := os.Open(pubringPath)
+	if err != nil {
+		return err
+	}
+	defer pubringFile.Close()
+
+	ctx.PublicRing, err = openpgp.ReadKeyRing(pubringFile)
+	return err
+}
+
+// FilesPath returns the directory holding the encrypted secrets.
+func (ctx *SecureContext) FilesPath() string {
+	return path.Join(ctx.DirectoryRoot, "files")
+}
+
+// SecretName returns the name of the secret stored at filePath: its path
+// relative to the files directory, without the .gpg extension.
+func (ctx *SecureContext) SecretName(filePath string) string {
+	name, err := filepath.Rel(ctx.FilesPath(), filePath)
+	if err != nil {
+		name = filepath.Base(filePath)
+	}
+	return strings.TrimSuffix(filepath.ToSlash(name), ".gpg")
+}
+
+// SecretPath returns the path of the encrypted file holding the named secret.
+func (ctx *SecureContext) SecretPath(name string) string {
+	return path.Join(ctx.FilesPath(), strings.TrimSuffix(name, ".gpg")+".gpg")
+}
+
+// WalkSecrets calls fn for every encrypted secret under the files directory
+// that is selected by ctx.Filter. Errors are recorded in ctx.Failures, when
+// set, instead of ending the walk.
+func (ctx *SecureContext) WalkSecrets(fn func(filePath string) error) error {
+	fileCallback := func(filePath string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return ctx.fileFailed(filePath, err)
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		if filepath.Ext(fi.Name()) != ".gpg" {
+			return nil
+		}
+		if !ctx.Filter.Match(ctx.SecretName(filePath)) {
+			return nil
+		}
+		return ctx.fileFailed(filePath, fn(filePath))
+	}
+
+	return filepath.Walk(ctx.FilesPath(), fileCallback)
+}
+
+func GetKeyByEmail(keyRing openpgp.EntityList, emailAddress string) *openpgp.Entity {
+	for _, entity := range keyRing {
+		for _, ident := range entity.Identities {
+			if ident.UserId.Email == emailAddress {
+				if entity.PrimaryKey.PublicKey == nil {
+					return nil
+				}
+				return entity
+			}
+		}
+	}
+	return nil
+}
+
+func (ctx *SecureContext) ReadAccessList() (openpgp.EntityList, error) {
+	return ctx.readKeyList(AccessListFileName)
+}
+
+// ReadWriters returns the keys allowed to sign secrets: those listed in
+// writers.conf or, without one, those of the access list.
+func (ctx *SecureContext) ReadWriters() (openpgp.EntityList, error) {
+	entityList, err := ctx.readKeyList(WritersFileName)
+	if os.IsNotExist(err) {
+		return ctx.ReadAccessList()
+	}
+	return entityList, err
+}
+
+// readKeyList reads a file of the project directory listing the email
+// addresses of keys of the public key ring, one per line.
+func (ctx *SecureContext) readKeyList(fileName string) (openpgp.EntityList, error) {
+	fp, err := os.Open(path.Join(ctx.DirectoryRoot, fileName))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	commentRegex, err := regexp.Compile("^#")
+	if err != nil {
+		return nil, err
+	}
+
+	entityList := openpgp.EntityList{}
+
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if commentRegex.Match([]byte(line)) {
+			continue
+		}
+		entity := GetKeyByEmail(ctx.PublicRing, strings.TrimSpace(line))
+		if entity == nil {
+			return nil, errors.New(line + " not in keyring")
+		}
+		entityList = append(entityList, entity)
+	}
+	return entityList, nil
+}
+
+// EncryptRoot encrypts every plaintext file to the access list and reports
+// whether each secret was created, updated or left unchanged. Secrets whose
+// plaintext and recipients are unchanged are not rewritten, so that their
+// ciphertext does not churn. The .gitignore block is updated to cover new
+// secrets.
+func (ctx *SecureContext) EncryptRoot() error {
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		return err
+	}
+
+	ctx.state, err = ctx.LoadState()
+	if err != nil {
+		return err
+	}
+
+	err = ctx.walkPlaintext(func(filePath string) error {
+		return ctx.encryptFile(filePath, entityList)
+	})
+	if saveErr := ctx.state.Save(); err == nil {
+		err = saveErr
+	}
+	if err == nil {
+		err = ctx.UpdateGitignore()
+	}
+	return err
+}
+
+// walkPlaintext calls fn for every plaintext file that EncryptRoot encrypts.
+// Errors are handled like in WalkSecrets.
+func (ctx *SecureContext) walkPlaintext(fn func(filePath string) error) error {
+	extensions, err := ctx.plaintextExtensions()
+	if err != nil {
+		return err
+	}
+
+	ignore, err := ctx.ignoreList()
+	if err != nil {
+		return err
+	}
+
+	return ctx.walkTree(ctx.plaintextDir(), ignore, func(filePath string) error {
+		if !ctx.isPlaintext(filePath, extensions) {
+			return nil
+		}
+		return ctx.fileFailed(filePath, fn(filePath))
+	})
+}
+
+// ciphertextPath returns the path of the encrypted counterpart of the
+// plaintext file at filePath: its path relative to the plaintext directory,
+// below the files directory, with .gpg appended.
+func (ctx *SecureContext) ciphertextPath(filePath string) string {
+	rel, err := filepath.Rel(ctx.plaintextDir(), filePath)
+	if err != nil {
+		rel = filepath.Base(filePath)
+	}
+	return filepath.Join(ctx.FilesPath(), rel+".gpg")
+}
+
+// plaintextPath returns the path DecryptRoot writes the secret at filePath to,
+// the inverse of ciphertextPath.
+func (ctx *SecureContext) plaintextPath(filePath string) string {
+	return filepath.Join(ctx.plaintextDir(), filepath.FromSlash(ctx.SecretName(filePath)))
+}
+
+// encryptFile encrypts the plaintext at filePath unless its ciphertext is
+// already current. It refuses to overwrite ciphertext that changed since the
+// plaintext was last synchronized.
+func (ctx *SecureContext) encryptFile(filePath string, entityList openpgp.EntityList) error {
+	if err := ctx.checkLegacyPlaintext(filePath); err != nil {
+		return err
+	}
+	plaintext, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+
+	destPath := ctx.ciphertextPath(filePath)
+	name := ctx.SecretName(destPath)
+
+	err = os.MkdirAll(filepath.Dir(destPath), 0700)
+	if err != nil {
+		return err
+	}
+
+	status := SecretCreated
+	if ciphertext, err := ioutil.ReadFile(destPath); err == nil {
+		status = SecretUpdated
+		upstream, err := ctx.ReadSecret(destPath)
+		switch {
+		case err == nil && bytes.Equal(upstream, plaintext):
+			if recipientsCurrent(destPath, entityList) {
+				ctx.state.Record(name, ciphertext, plaintext)
+				ctx.progress(SecretUnchanged, name)
+				return nil
+			}
+		case ctx.state.UpstreamChanged(name, ciphertext):
+			if err != nil {
+				return err
+			}
+			if !ctx.state.LocalChanged(name, plaintext) {
+				return errStale
+			}
+			return ctx.conflict(filePath, plaintext, upstream)
+		}
+	}
+
+	var ciphertext bytes.Buffer
+	err = WriteFileAtomic(destPath, 0644, func(destFp io.Writer) error {
+		return ctx.Encrypt(io.MultiWriter(destFp, &ciphertext), plaintext, entityList)
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx.state.Record(name, ciphertext.Bytes(), plaintext)
+	ctx.progress(status, name)
+	return nil
+}
+
+func (ctx *SecureContext) progress(status, name string) {
+	if ctx.Progress != nil {
+		ctx.Progress(status, name)
+	}
+}
+
+func (ctx *SecureContext) warn(err error) {
+	if ctx.Warn != nil {
+		ctx.Warn(err)
+	}
+}
+
+// Encrypt writes plaintext to w, armored, encrypted to entityList and signed
+// with the key returned by Signer.
+func (ctx *SecureContext) Encrypt(w io.Writer, plaintext []byte, entityList openpgp.EntityList) error {
+	signer, err := ctx.Signer()
+	if err != nil {
+		return err
+	}
+
+	armored, err := armor.Encode(w, "PGP MESSAGE", nil)
+	if err != nil {
+		return err
+	}
+	cleartext, err := openpgp.Encrypt(armored, entityList, signer, nil, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := cleartext.Write(plaintext); err != nil {
+		return err
+	}
+	if err := cleartext.Close(); err != nil {
+		return err
+	}
+	return armored.Close()
+}
+
+// recipientsCurrent reports whether the ciphertext at cipherPath is encrypted
+// to exactly entityList.
+func recipientsCurrent(cipherPath string, entityList openpgp.EntityList) bool {
+	keyIds, err := ReadRecipients(cipherPath)
+	if err != nil {
+		return false
+	}
+	missing, unexpected := compareRecipients(entityList, keyIds)
+	return len(missing) == 0 && len(unexpected) == 0
+}
+
+// DecryptRoot decrypts every secret into the plaintext directory, whatever
+// its extension. Plaintext with local changes is never overwritten: if the
+// ciphertext is unchanged since the last sync the file is skipped with a
+// warning, otherwise it is a conflict. Old plaintext of a secret, as reported
+// by checkLegacyPlaintext, is warned about. The .gitignore block is updated
+// first, so that plaintext is never left unignored.
+func (ctx *SecureContext) DecryptRoot() error {
+	if err := ctx.preparePlaintextDir(ctx.plaintextDir()); err != nil {
+		return err
+	}
+	if err := ctx.UpdateGitignore(); err != nil {
+		return err
+	}
+
+	var err error
+	ctx.state, err = ctx.LoadState()
+	if err != nil {
+		return err
+	}
+
+	err = ctx.WalkSecrets(ctx.decryptFile)
+	if saveErr := ctx.state.Save(); err == nil {
+		err = saveErr
+	}
+	return err
+}
+
+func (ctx *SecureContext) decryptFile(filePath string) error {
+	ciphertext, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return err
+	}
+	plaintext, err := ctx.ReadSecret(filePath)
+	if err != nil {
+		return err
+	}
+
+	name := ctx.SecretName(filePath)
+	newFilePath := ctx.plaintextPath(filePath)
+	if local, err := ioutil.ReadFile(newFilePath); err == nil && !bytes.Equal(local, plaintext) {
+		if ctx.state.LocalChanged(name, local) {
+			if !ctx.state.UpstreamChanged(name, ciphertext) {
+				ctx.warn(&FileError{newFilePath, errLocalChanges})
+				return nil
+			}
+			return ctx.conflict(newFilePath, local, plaintext)
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(newFilePath), PlaintextDirMode)
+	if err != nil {
+		return err
+	}
+	err = WriteFileAtomic(newFilePath, PlaintextFileMode, func(fp io.Writer) error {
+		_, err := fp.Write(plaintext)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	ctx.state.Record(name, ciphertext, plaintext)
+
+	legacyPath := newFilePath + ".txt"
+	if _, err := os.Stat(legacyPath); err == nil {
+		if err := ctx.checkLegacyPlaintext(legacyPath); err != nil {
+			ctx.warn(&FileError{legacyPath, err})
+		}
+	}
+	return nil
+}
+
+// ReadSecret decrypts the secret at filePath and returns its contents,
+// checking the signature if it is signed.
+func (ctx *SecureContext) ReadSecret(filePath string) ([]byte, error) {
+	md, err := ctx.DecryptFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.CheckSignature(md); err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// Open returns a reader of the plaintext of the named secret. The signature
+// is checked once the plaintext has been read: at EOF, Read returns a
+// *BadSignatureError instead of io.EOF if it does not verify.
+func (ctx *SecureContext) Open(name string) (io.ReadCloser, error) {
+	secfile, err := os.Open(ctx.SecretPath(name))
+	if err != nil {
+		return nil, err
+	}
+	md, err := ctx.DecryptReader(secfile)
+	if err != nil {
+		secfile.Close()
+		return nil, err
+	}
+	return &secretReader{ctx: ctx, md: md, file: secfile}, nil
+}
+
+// secretReader reads the body of a decrypted message and checks its
+// signature at EOF. The body must not be read again after EOF, which would
+// fail the integrity check.
+type secretReader struct {
+	ctx  *SecureContext
+	md   *openpgp.MessageDetails
+	file *os.File
+	err  error
+}
+
+func (r *secretReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.md.UnverifiedBody.Read(p)
+	if err == io.EOF {
+		if sigErr := r.ctx.CheckSignature(r.md); sigErr != nil {
+			err = sigErr
+		}
+	}
+	r.err = err
+	return n, err
+}
+
+func (r *secretReader) Close() error {
+	return r.file.Close()
+}
+
+// DecryptFile decrypts the secret at filePath. The ciphertext is read whole
+// and the file closed before decrypting, since callers read the returned body
+// without closing anything; use Open to stream a secret instead.
+func (ctx *SecureContext) DecryptFile(filePath string) (*openpgp.MessageDetails, error) {
+	secfile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer secfile.Close()
+	ciphertext, err := ioutil.ReadAll(secfile)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.DecryptReader(bytes.NewReader(ciphertext))
+}
+
+// DecryptReader decrypts the armored secret read from r.
+func (ctx *SecureContext) DecryptReader(r io.Reader) (*openpgp.MessageDetails, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return nil, &ArmorError{err}
+	}
+	body := block.Body
+	if ctx.Agent != nil {
+		ciphertext, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		md, err := ctx.decryptWithAgent(bytes.NewReader(ciphertext))
+		if !isAgentFailure(err) {
+			return md, err
+		}
+		if err := ctx.dropAgent(err); err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(ciphertext)
+	}
+
+	promptCallback := func(keys []openpgp.Key, symmetric bool) ([]byte, error) {
+		for _, k := range keys {
+			err := k.PrivateKey.Decrypt([]byte(ctx.Password))
+			if err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+		return nil, errNoPrivateKey
+	}
+
+	return openpgp.ReadMessage(body, ctx.keyRing(), promptCallback, nil)
+}
+
+// keyRing returns the keys messages are read with: the private keys, which
+// decrypt them, followed by the public key ring, so that signatures of
+// teammates are checked too.
+func (ctx *SecureContext) keyRing() openpgp.EntityList {
+	keyRing := make(openpgp.EntityList, 0, len(ctx.PrivateRing)+len(ctx.PublicRing))
+	keyRing = append(keyRing, ctx.PrivateRing...)
+	return append(keyRing, ctx.PublicRing...)
+}
+
+// Signer returns the entity secrets are signed with: the first one of the
+// private key ring that can sign, with its private keys decrypted.
+func (ctx *SecureContext) Signer() (*openpgp.Entity, error) {
+	if ctx.signer != nil {
+		return ctx.signer, nil
+	}
+
+	for _, entity := range ctx.PrivateRing {
+		if entity.PrivateKey == nil || !entity.PrivateKey.CanSign() {
+			continue
+		}
+		if err := entity.PrivateKey.Decrypt([]byte(ctx.Password)); err != nil {
+			return nil, errNoPrivateKey
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil {
+				if err := subkey.PrivateKey.Decrypt([]byte(ctx.Password)); err != nil {
+					return nil, errNoPrivateKey
+				}
+			}
+		}
+		ctx.signer = entity
+		return entity, nil
+	}
+	return nil, errors.New("no private key that can sign")
+}
+
+func expandPath(p string) (string, error) {
+	if path.IsAbs(p) {
+		return p, nil
+	}
+	if p[:2] == "~/" {
+		usr, err := user.Current()
+		if err != nil {
+			return "", err
+		}
+		p = strings.Replace(p, "~", usr.HomeDir, 1)
+	}
+	return p, nil
+}
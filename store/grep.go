@@ -0,0 +1,192 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// GrepOptions mirrors the subset of grep(1) options supported by Grep.
+type GrepOptions struct {
+	Patterns         []string
+	IgnoreCase       bool
+	InvertMatch      bool
+	WordRegexp       bool
+	FixedStrings     bool
+	Count            bool
+	FilesWithMatches bool
+	BeforeContext    int
+	AfterContext     int
+}
+
+// Compile joins the patterns into a single regular expression honoring the
+// -i, -w and -F options.
+func (opts *GrepOptions) Compile() (*regexp.Regexp, error) {
+	if len(opts.Patterns) == 0 {
+		return nil, errors.New("no pattern specified")
+	}
+
+	alternates := make([]string, 0, len(opts.Patterns))
+	for _, pattern := range opts.Patterns {
+		if opts.FixedStrings {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		alternates = append(alternates, "(?:"+pattern+")")
+	}
+
+	expr := strings.Join(alternates, "|")
+	if opts.WordRegexp {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if opts.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.Compile(expr)
+}
+
+// GrepLine is a line selected by Grep, or a context line around one.
+type GrepLine struct {
+	Number  uint64
+	Text    string
+	Context bool
+	Matches []MatchOffset
+}
+
+// GrepResult holds the lines selected from a single secret. Binary secrets
+// are not searched.
+type GrepResult struct {
+	Record SecretRecord
+	Lines  []GrepLine
+	Count  uint64
+	Binary bool
+}
+
+// binaryPeekSize is how much of a secret is checked for NUL bytes, as git and
+// GNU grep do, to tell binary secrets from text.
+const binaryPeekSize = 8000
+
+// maxLineSize bounds the lines Grep reads. Secrets such as base64 encoded
+// keys can be a single line far longer than the bufio.Scanner default.
+const maxLineSize = 64 << 20
+
+// Grep searches every secret under the files directory and calls fn with the
+// result for each one. It reports whether any line was selected.
+func (ctx *SecureContext) Grep(opts *GrepOptions, fn func(*GrepResult) error) (bool, error) {
+	var err error
+	ctx.SearchRegex, err = opts.Compile()
+	if err != nil {
+		return false, err
+	}
+
+	selected := false
+	err = ctx.WalkSecrets(func(filePath string) error {
+		md, err := ctx.DecryptFile(filePath)
+		if err != nil {
+			return err
+		}
+
+		result, err := grepReader(md.UnverifiedBody, ctx.SearchRegex, opts)
+		if err != nil {
+			return err
+		}
+		if result.Binary {
+			return nil
+		}
+		if err := ctx.CheckSignature(md); err != nil {
+			return err
+		}
+		if result.Count > 0 {
+			selected = true
+		}
+		result.Record = ctx.secretRecord(filePath, md.EncryptedToKeyIds, md)
+		return fn(result)
+	})
+	return selected, err
+}
+
+// grepReader scans a single decrypted secret, honoring the context options.
+func grepReader(r io.Reader, regex *regexp.Regexp, opts *GrepOptions) (*GrepResult, error) {
+	result := &GrepResult{}
+	var before []GrepLine
+	afterRemaining := 0
+	listing := opts.Count || opts.FilesWithMatches
+
+	// The head is read once and replayed: reading a decrypted body again
+	// after it hit EOF fails its integrity check.
+	head := make([]byte, binaryPeekSize)
+	n, err := io.ReadFull(r, head)
+	head = head[:n]
+	if bytes.IndexByte(head, 0) >= 0 {
+		result.Binary = true
+		return result, nil
+	}
+	br := io.Reader(bytes.NewReader(head))
+	switch err {
+	case nil:
+		br = io.MultiReader(br, r)
+	case io.EOF, io.ErrUnexpectedEOF:
+	default:
+		return nil, err
+	}
+
+	lineNumber := uint64(0)
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), maxLineSize)
+	for scanner.Scan() {
+		lineNumber++
+		line := GrepLine{Number: lineNumber, Text: scanner.Text(), Matches: []MatchOffset{}}
+
+		locs := regex.FindAllStringIndex(line.Text, -1)
+		if !opts.InvertMatch {
+			for _, loc := range locs {
+				line.Matches = append(line.Matches, MatchOffset{loc[0], loc[1]})
+			}
+		}
+
+		if (len(locs) > 0) == opts.InvertMatch {
+			line.Context = true
+			if listing {
+				continue
+			}
+			if afterRemaining > 0 {
+				result.Lines = append(result.Lines, line)
+				afterRemaining--
+			} else if opts.BeforeContext > 0 {
+				before = append(before, line)
+				if len(before) > opts.BeforeContext {
+					before = before[1:]
+				}
+			}
+			continue
+		}
+
+		result.Count++
+		if listing {
+			continue
+		}
+		result.Lines = append(result.Lines, before...)
+		before = before[:0]
+		result.Lines = append(result.Lines, line)
+		afterRemaining = opts.AfterContext
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGrep(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "logins", "user: alice\npassword: Hunter2\nurl: https://example.com/login\n")
+	writeTestSecret(t, ctx, "notes", "hunter season\nalicebob\n")
+	writeTestSecret(t, ctx, "keys/blob", "hunter\x00binary")
+	writeTestSecret(t, ctx, "keys/long", strings.Repeat("x", 200<<10)+"hunter\n")
+
+	// want maps the secrets that fn is called for to the numbers of their
+	// lines, or to their count with -c and -l.
+	tests := []struct {
+		name string
+		opts GrepOptions
+		want map[string][]uint64
+	}{
+		{"match", GrepOptions{Patterns: []string{"hunter"}},
+			map[string][]uint64{"logins": nil, "notes": {1}, "keys/long": {1}}},
+		{"ignore case", GrepOptions{Patterns: []string{"hunter"}, IgnoreCase: true},
+			map[string][]uint64{"logins": {2}, "notes": {1}, "keys/long": {1}}},
+		{"invert", GrepOptions{Patterns: []string{"alice"}, InvertMatch: true},
+			map[string][]uint64{"logins": {2, 3}, "notes": {1}, "keys/long": {1}}},
+		{"word", GrepOptions{Patterns: []string{"alice"}, WordRegexp: true},
+			map[string][]uint64{"logins": {1}, "notes": nil, "keys/long": nil}},
+		{"count", GrepOptions{Patterns: []string{"a"}, Count: true},
+			map[string][]uint64{"logins": {3}, "notes": {2}, "keys/long": {0}}},
+		{"files with matches", GrepOptions{Patterns: []string{"alice"}, FilesWithMatches: true},
+			map[string][]uint64{"logins": {1}, "notes": {1}, "keys/long": {0}}},
+		{"patterns", GrepOptions{Patterns: []string{"user", "season"}},
+			map[string][]uint64{"logins": {1}, "notes": {1}, "keys/long": nil}},
+		{"fixed strings", GrepOptions{Patterns: []string{"https://example.com/"}, FixedStrings: true},
+			map[string][]uint64{"logins": {3}, "notes": nil, "keys/long": nil}},
+		{"context", GrepOptions{Patterns: []string{"password"}, BeforeContext: 1, AfterContext: 1},
+			map[string][]uint64{"logins": {1, 2, 3}, "notes": nil, "keys/long": nil}},
+		{"no match", GrepOptions{Patterns: []string{"nothing"}},
+			map[string][]uint64{"logins": nil, "notes": nil, "keys/long": nil}},
+	}
+	for _, test := range tests {
+		got := map[string][]uint64{}
+		selected, err := ctx.Grep(&test.opts, func(result *GrepResult) error {
+			if test.opts.Count || test.opts.FilesWithMatches {
+				got[result.Record.Secret] = []uint64{result.Count}
+				return nil
+			}
+			var lines []uint64
+			for _, line := range result.Lines {
+				lines = append(lines, line.Number)
+			}
+			got[result.Record.Secret] = lines
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got %v, want %v", test.name, got, test.want)
+		}
+
+		wantSelected := false
+		for _, lines := range test.want {
+			if len(lines) > 0 && lines[0] > 0 {
+				wantSelected = true
+			}
+		}
+		if selected != wantSelected {
+			t.Errorf("%v: selected %v, want %v", test.name, selected, wantSelected)
+		}
+	}
+}
+
+func TestGrepContextLines(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "s", "a\nb\nmatch\nc\nd\n")
+
+	var lines []GrepLine
+	_, err := ctx.Grep(&GrepOptions{Patterns: []string{"match"}, BeforeContext: 1, AfterContext: 1}, func(result *GrepResult) error {
+		lines = result.Lines
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []GrepLine{
+		{Number: 2, Text: "b", Context: true, Matches: []MatchOffset{}},
+		{Number: 3, Text: "match", Matches: []MatchOffset{{0, 5}}},
+		{Number: 4, Text: "c", Context: true, Matches: []MatchOffset{}},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("got %+v, want %+v", lines, want)
+	}
+}
+
+func TestGrepOptionsCompile(t *testing.T) {
+	if _, err := (&GrepOptions{}).Compile(); err == nil {
+		t.Error("no pattern: got no error")
+	}
+	if _, err := (&GrepOptions{Patterns: []string{"("}}).Compile(); err == nil {
+		t.Error("bad pattern: got no error")
+	}
+	re, err := (&GrepOptions{Patterns: []string{"a.c", "x"}, FixedStrings: true, IgnoreCase: true}).Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !re.MatchString("A.C") || re.MatchString("abc") {
+		t.Errorf("%v: -F -i does not match literally", re)
+	}
+}
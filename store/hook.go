@@ -0,0 +1,230 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// git runs a git command in workTree and returns its standard output. The
+// error includes what git wrote to its standard error.
+func git(workTree string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = workTree
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = errors.New(msg)
+		}
+		return nil, fmt.Errorf("git %v: %v", strings.Join(args, " "), err)
+	}
+	return out, nil
+}
+
+// gitBlob returns the contents of the blob named by object, such as
+// ":path" for the staged version of path, or false if there is none.
+func gitBlob(workTree, object string) ([]byte, bool) {
+	cmd := exec.Command("git", "cat-file", "blob", object)
+	cmd.Dir = workTree
+	out, err := cmd.Output()
+	return out, err == nil
+}
+
+// StagedFiles returns the slash separated paths, relative to workTree, of the
+// files added, copied, modified or renamed in the index.
+func StagedFiles(workTree string) ([]string, error) {
+	out, err := git(workTree, "diff", "--cached", "--name-only", "-z", "--diff-filter=ACMR")
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			files = append(files, name)
+		}
+	}
+	return files, nil
+}
+
+// ProjectOf returns the directory, relative to workTree, of the innermost
+// project containing the staged file rel: the closest directory with an
+// access list. It returns false for files outside of any project.
+func ProjectOf(workTree, rel string) (string, bool) {
+	dir := rel
+	for dir != "." {
+		dir = path.Dir(dir)
+		if _, err := os.Stat(filepath.Join(workTree, filepath.FromSlash(dir), AccessListFileName)); err == nil {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// StagedCheck checks the files staged in a project before a commit.
+type StagedCheck struct {
+	ctx        *SecureContext
+	workTree   string
+	accessList openpgp.EntityList
+	writers    openpgp.EntityList
+
+	// Report is called for every problem found, with the path of the
+	// offending file relative to the work tree.
+	Report func(filePath, problem string)
+}
+
+// NewStagedCheck prepares checking the project of ctx, which is inside
+// workTree.
+func (ctx *SecureContext) NewStagedCheck(workTree string) (*StagedCheck, error) {
+	accessList, err := ctx.ReadAccessList()
+	if err != nil {
+		return nil, err
+	}
+	writers, err := ctx.ReadWriters()
+	if err != nil {
+		return nil, err
+	}
+	return &StagedCheck{
+		ctx:        ctx,
+		workTree:   workTree,
+		accessList: accessList,
+		writers:    writers,
+	}, nil
+}
+
+func (check *StagedCheck) report(filePath, problem string) {
+	if rel, err := filepath.Rel(check.workTree, filePath); err == nil {
+		filePath = rel
+	}
+	check.Report(filepath.ToSlash(filePath), problem)
+}
+
+// Run checks the staged files, relative to the work tree and all inside the
+// project. It rejects plaintext that is tracked or staged and ciphertext that
+// is not encrypted to the access list, not signed by a writer or does not
+// match its decrypted plaintext. Staged ciphertext is read from the index.
+func (check *StagedCheck) Run(files []string) error {
+	ctx := check.ctx
+	extensions, err := ctx.plaintextExtensions()
+	if err != nil {
+		return err
+	}
+	ignore, err := ctx.ignoreList()
+	if err != nil {
+		return err
+	}
+
+	tracked, err := ctx.TrackedPlaintext()
+	if err != nil {
+		return err
+	}
+	reported := map[string]bool{}
+	for _, plainPath := range tracked {
+		abs, _ := filepath.Abs(plainPath)
+		reported[abs] = true
+		check.report(abs, "plaintext of a secret is staged, remove it with git rm --cached")
+	}
+
+	filesPath, err := filepath.Abs(ctx.FilesPath())
+	if err != nil {
+		return err
+	}
+	plainDir, err := filepath.Abs(ctx.plaintextDir())
+	if err != nil {
+		return err
+	}
+	for _, rel := range files {
+		filePath := filepath.Join(check.workTree, filepath.FromSlash(rel))
+		if insideDir(filesPath, filePath) {
+			if filepath.Ext(filePath) == ".gpg" {
+				check.secret(rel, filePath)
+			}
+			continue
+		}
+
+		plainRel, err := filepath.Rel(plainDir, filePath)
+		if err != nil || reported[filePath] || ignore.IgnoredPath(filepath.ToSlash(plainRel)) {
+			continue
+		}
+		if !ctx.isPlaintext(filePath, extensions) {
+			continue
+		}
+		// Files going through the gosec filter are staged encrypted.
+		if attr, err := filterAttribute(check.workTree, rel); err != nil {
+			return err
+		} else if attr == FilterName {
+			if staged, _ := gitBlob(check.workTree, ":"+rel); !IsArmored(staged) {
+				check.report(filePath, "staged without going through the gosec filter")
+			}
+			continue
+		}
+		check.report(filePath, "plaintext is staged, encrypt it with gosec -e and unstage it")
+	}
+	return nil
+}
+
+// secret checks the staged ciphertext rel, at filePath in the work tree.
+func (check *StagedCheck) secret(rel, filePath string) {
+	ctx := check.ctx
+	ciphertext, err := git(check.workTree, "cat-file", "blob", ":"+rel)
+	if err != nil {
+		check.report(filePath, err.Error())
+		return
+	}
+
+	keyIds, err := readRecipients(bytes.NewReader(ciphertext))
+	if err != nil {
+		check.report(filePath, err.Error())
+		return
+	}
+	if record := ctx.auditSecret(filePath, keyIds, check.accessList); !record.OK() {
+		check.report(filePath, "not encrypted to the access list: "+record.Problems())
+	}
+
+	md, err := ctx.DecryptReader(bytes.NewReader(ciphertext))
+	if err != nil {
+		check.report(filePath, err.Error())
+		return
+	}
+	plaintext, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		check.report(filePath, err.Error())
+		return
+	}
+	switch {
+	case !md.IsSigned:
+		check.report(filePath, "not signed")
+	case md.SignedBy == nil:
+		check.report(filePath, "signed by unknown key "+KeyName(ctx.PublicRing, md.SignedByKeyId))
+	case md.SignatureError != nil:
+		check.report(filePath, ctx.CheckSignature(md).Error())
+	case len(check.writers.KeysById(md.SignedByKeyId)) == 0:
+		check.report(filePath, "signed by "+KeyName(ctx.PublicRing, md.SignedByKeyId)+", who is not a writer")
+	}
+
+	if local, err := ioutil.ReadFile(ctx.plaintextPath(filePath)); err == nil && !bytes.Equal(local, plaintext) {
+		check.report(filePath, "plaintext has changes that are not encrypted, run gosec -e")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// writeForeignSecret encrypts plaintext to the access list of ctx as the
+// named secret, signed by a new key that is in no key ring.
+func writeForeignSecret(t *testing.T, ctx *SecureContext, name, plaintext string) {
+	t.Helper()
+	carol, err := openpgp.NewEntity("carol", "", "carol@example.com", &packet.Config{RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entityList, err := ctx.ReadAccessList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secretPath := ctx.SecretPath(name)
+	if err := os.MkdirAll(filepath.Dir(secretPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+	err = WriteFileAtomic(secretPath, 0644, func(w io.Writer) error {
+		armored, err := armor.Encode(w, "PGP MESSAGE", nil)
+		if err != nil {
+			return err
+		}
+		cleartext, err := openpgp.Encrypt(armored, entityList, carol, nil, nil)
+		if err != nil {
+			return err
+		}
+		io.WriteString(cleartext, plaintext)
+		if err := cleartext.Close(); err != nil {
+			return err
+		}
+		return armored.Close()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStagedCheckSigners(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	alice := newTestContext(t)
+	dir := alice.DirectoryRoot
+	writeTestFile(t, filepath.Join(dir, AccessListFileName), "alice@example.com\nbob@example.com\n")
+	// Bob's public key is only in the public key ring of alice.
+	bob := newTestContextFor(t, dir, testBobRing)
+
+	writeTestSecret(t, alice, "alice", "a\n")
+	writeTestSecret(t, bob, "bob", "b\n")
+	writeForeignSecret(t, alice, "carol", "c\n")
+	if _, err := git(dir, "init", "-q"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git(dir, "add", "files"); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() map[string]string {
+		t.Helper()
+		check, err := alice.NewStagedCheck(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems := map[string]string{}
+		check.Report = func(filePath, problem string) { problems[filePath] = problem }
+		if err := check.Run([]string{"files/alice.gpg", "files/bob.gpg", "files/carol.gpg"}); err != nil {
+			t.Fatal(err)
+		}
+		return problems
+	}
+
+	problems := check()
+	if !strings.HasPrefix(problems["files/carol.gpg"], "signed by unknown key") {
+		t.Errorf("files/carol.gpg: got %q, want an unknown key", problems["files/carol.gpg"])
+	}
+	delete(problems, "files/carol.gpg")
+	if len(problems) != 0 {
+		t.Errorf("secrets of writers rejected: %v", problems)
+	}
+
+	writeTestFile(t, filepath.Join(dir, WritersFileName), "alice@example.com\n")
+	problems = check()
+	if want := "signed by bob@example.com, who is not a writer"; problems["files/bob.gpg"] != want {
+		t.Errorf("files/bob.gpg: got %q, want %q", problems["files/bob.gpg"], want)
+	}
+	if _, ok := problems["files/alice.gpg"]; ok {
+		t.Errorf("files/alice.gpg: %v", problems["files/alice.gpg"])
+	}
+}
+
+func TestCheckSignatureUnknownSigner(t *testing.T) {
+	ctx := newTestContext(t)
+	writeForeignSecret(t, ctx, "carol", "c\n")
+
+	// Without a list of writers, anyone may sign.
+	plaintext, err := ctx.ReadSecret(ctx.SecretPath("carol"))
+	if err != nil || string(plaintext) != "c\n" {
+		t.Errorf("got %q, %v, want the plaintext", plaintext, err)
+	}
+
+	writeTestFile(t, filepath.Join(ctx.DirectoryRoot, WritersFileName), "alice@example.com\n")
+	_, err = ctx.ReadSecret(ctx.SecretPath("carol"))
+	if _, ok := err.(*UnknownSignerError); !ok {
+		t.Fatalf("got %v, want an *UnknownSignerError", err)
+	}
+	if cause := (&FileError{"carol", err}).Cause(); cause != CauseBadSignature {
+		t.Errorf("classified as %v", cause)
+	}
+
+	// Grep goes through the same check.
+	ctx.Failures = &FailureLog{}
+	if _, err := ctx.Grep(&GrepOptions{Patterns: []string{"c"}}, func(*GrepResult) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got := ctx.Failures.Errors; len(got) != 1 || !reflect.DeepEqual(got[0].Err, err) {
+		t.Errorf("grep failures: %v, want %v", got, err)
+	}
+}
@@ -0,0 +1,255 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IgnoreFileName lists, in the project directory, gitignore style patterns of
+// plaintext paths EncryptRoot skips. Patterns are relative to the plaintext
+// directory.
+const IgnoreFileName = ".gosecignore"
+
+// ignorePattern is a single line of an ignore file.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+}
+
+// IgnoreList matches slash separated paths against gitignore style patterns.
+// The last matching pattern wins.
+type IgnoreList struct {
+	patterns []ignorePattern
+}
+
+// ParseIgnore reads gitignore style patterns from r. Blank lines and lines
+// starting with # are skipped, ! negates a pattern, a trailing / matches only
+// directories, a pattern containing a / elsewhere is anchored to the root and
+// ** matches any number of directories.
+func ParseIgnore(r io.Reader) (*IgnoreList, error) {
+	list := &IgnoreList{}
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		if err := list.Add(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+	}
+	return list, scanner.Err()
+}
+
+// Add appends a single pattern line to the list.
+func (list *IgnoreList) Add(line string) error {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	var p ignorePattern
+	switch {
+	case strings.HasPrefix(line, "!"):
+		p.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+
+	if !strings.Contains(line, "/") {
+		line = "**/" + line
+	}
+	for _, segment := range strings.Split(strings.TrimPrefix(line, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		if _, err := path.Match(segment, ""); err != nil {
+			return fmt.Errorf("bad pattern %q", line)
+		}
+		p.segments = append(p.segments, segment)
+	}
+	list.patterns = append(list.patterns, p)
+	return nil
+}
+
+// Ignored reports whether the slash separated path rel, relative to the root
+// of the list, is ignored.
+func (list *IgnoreList) Ignored(rel string, isDir bool) bool {
+	if list == nil {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	ignored := false
+	for _, p := range list.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if matchSegments(p.segments, parts) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// IgnoredPath reports whether the slash separated path of a file, relative to
+// the root of the list, is ignored, either itself or through one of its
+// directories.
+func (list *IgnoreList) IgnoredPath(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if list.Ignored(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return list.Ignored(rel, false)
+}
+
+func matchSegments(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(parts); i++ {
+				if matchSegments(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], parts[0]); !ok {
+			return false
+		}
+		pattern, parts = pattern[1:], parts[1:]
+	}
+	return len(parts) == 0
+}
+
+// ignoreList returns the patterns walkPlaintext skips: those of the project's
+// .gosecignore followed by the defaults, .git anywhere and the files
+// directory, which therefore cannot be negated.
+func (ctx *SecureContext) ignoreList() (*IgnoreList, error) {
+	list := &IgnoreList{}
+	fp, err := os.Open(path.Join(ctx.DirectoryRoot, IgnoreFileName))
+	if err == nil {
+		list, err = ParseIgnore(fp)
+		fp.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", IgnoreFileName, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	list.Add(".git/")
+	root, files := filepath.Clean(ctx.plaintextDir()), filepath.Clean(ctx.FilesPath())
+	if root != files && insideDir(root, files) {
+		rel, _ := filepath.Rel(root, files)
+		list.Add("/" + filepath.ToSlash(rel) + "/")
+	}
+	return list, nil
+}
+
+// OutsideRootError is returned for symlinks that resolve outside the
+// plaintext directory.
+type OutsideRootError struct {
+	Target string
+}
+
+func (e *OutsideRootError) Error() string {
+	return fmt.Sprintf("symlink leaves the plaintext directory: %v", e.Target)
+}
+
+// walkTree calls fn for every regular file below root that ignore does not
+// skip. Symlinks are skipped unless ctx.FollowSymlinks is set, in which case
+// they are followed as long as they resolve inside root; any other symlink is
+// reported as an error. fn receives the path through the link, so a linked
+// file maps to the secret named after the link.
+func (ctx *SecureContext) walkTree(root string, ignore *IgnoreList, fn func(filePath string) error) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	visited := map[string]bool{realRoot: true}
+
+	var walkDir func(dir, rel string) error
+	walkDir = func(dir, rel string) error {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return ctx.fileFailed(dir, err)
+		}
+		for _, fi := range entries {
+			filePath := filepath.Join(dir, fi.Name())
+			entryRel := path.Join(rel, fi.Name())
+
+			if fi.Mode()&os.ModeSymlink != 0 {
+				if !ctx.FollowSymlinks || ignore.Ignored(entryRel, false) {
+					continue
+				}
+				target, err := filepath.EvalSymlinks(filePath)
+				if err == nil && !insideDir(realRoot, target) {
+					err = &OutsideRootError{target}
+				}
+				if err == nil {
+					fi, err = os.Stat(target)
+				}
+				if err != nil {
+					if err := ctx.fileFailed(filePath, err); err != nil {
+						return err
+					}
+					continue
+				}
+				if fi.IsDir() {
+					if visited[target] {
+						continue
+					}
+					visited[target] = true
+				}
+			}
+
+			if ignore.Ignored(entryRel, fi.IsDir()) {
+				continue
+			}
+			if fi.IsDir() {
+				err = walkDir(filePath, entryRel)
+			} else if fi.Mode().IsRegular() {
+				err = fn(filePath)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walkDir(root, "")
+}
+
+// insideDir reports whether filePath is dir or below it. Both must be clean.
+func insideDir(dir, filePath string) bool {
+	rel, err := filepath.Rel(dir, filePath)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
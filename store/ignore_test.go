@@ -0,0 +1,149 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// parseTestIgnore parses the ignore file made of lines.
+func parseTestIgnore(t *testing.T, lines ...string) *IgnoreList {
+	t.Helper()
+	list, err := ParseIgnore(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
+
+func TestIgnored(t *testing.T) {
+	list := parseTestIgnore(t,
+		"# backups",
+		"*.bak",
+		"\\#notes",
+		"\\!bang",
+		"/top.txt",
+		"dir/a.txt",
+		"build/",
+		"a/**/z.txt",
+		"**/cache",
+		"*.log",
+		"!keep.log",
+		"trailing.txt   ",
+		"",
+	)
+	ignored := []string{
+		"a.bak", "dir/sub/a.bak", "#notes", "!bang", "top.txt", "dir/a.txt",
+		"a/z.txt", "a/b/c/z.txt", "drop.log", "trailing.txt",
+	}
+	kept := []string{
+		"a.bak.txt", "# backups", "dir/top.txt", "other/dir/a.txt", "build",
+		"keep.log", "trailing.txt   ",
+	}
+	for _, rel := range ignored {
+		if !list.Ignored(rel, false) {
+			t.Errorf("file %v kept", rel)
+		}
+	}
+	for _, rel := range kept {
+		if list.Ignored(rel, false) {
+			t.Errorf("file %v ignored", rel)
+		}
+	}
+	if !list.Ignored("build", true) || !list.Ignored("x/y/cache", true) {
+		t.Error("directory patterns do not match directories")
+	}
+
+	// The last matching pattern wins.
+	if list := parseTestIgnore(t, "!keep.log", "*.log"); !list.Ignored("keep.log", false) {
+		t.Error("negation before the pattern it negates took effect")
+	}
+}
+
+func TestIgnoredPath(t *testing.T) {
+	list := parseTestIgnore(t, "tmp/", "/vendor", "!/tmp/keep.txt")
+	for _, rel := range []string{"tmp/a.txt", "sub/tmp/a.txt", "vendor/x/y.txt"} {
+		if !list.IgnoredPath(rel) {
+			t.Errorf("%v kept", rel)
+		}
+	}
+	// As with git, a file of an ignored directory cannot be negated.
+	if !list.IgnoredPath("tmp/keep.txt") {
+		t.Error("tmp/keep.txt kept, though tmp is ignored")
+	}
+	for _, rel := range []string{"sub/vendor/y.txt", "tmp.txt"} {
+		if list.IgnoredPath(rel) {
+			t.Errorf("%v ignored", rel)
+		}
+	}
+}
+
+func TestParseIgnoreBadPattern(t *testing.T) {
+	_, err := ParseIgnore(strings.NewReader("ok\n[z-a\n"))
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+		t.Errorf("got %v, want an error for line 2", err)
+	}
+}
+
+func TestWalkPlaintextIgnore(t *testing.T) {
+	ctx := newTestContext(t)
+	root := ctx.DirectoryRoot
+	for _, rel := range []string{"a.txt", "notes.bak", "tmp/b.txt", "sub/c.txt"} {
+		writeTestFile(t, filepath.Join(root, rel), rel)
+	}
+	writeTestFile(t, filepath.Join(root, IgnoreFileName), "*.bak\ntmp/\n")
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	writeTestFile(t, outside, "outside")
+	if err := os.Symlink(outside, filepath.Join(root, "link.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("a.txt", filepath.Join(root, "inside.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func() []string {
+		var walked []string
+		err := ctx.walkPlaintext(func(filePath string) error {
+			rel, _ := filepath.Rel(root, filePath)
+			walked = append(walked, filepath.ToSlash(rel))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(walked)
+		return walked
+	}
+
+	if got, want := walk(), []string{"a.txt", "sub/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+
+	ctx.FollowSymlinks = true
+	ctx.Failures = &FailureLog{}
+	if got, want := walk(), []string{"a.txt", "inside.txt", "sub/c.txt"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("following symlinks, walked %v, want %v", got, want)
+	}
+	if len(ctx.Failures.Errors) != 1 {
+		t.Fatalf("got failures %v, want link.txt", ctx.Failures.Errors)
+	}
+	if _, ok := ctx.Failures.Errors[0].Err.(*OutsideRootError); !ok {
+		t.Errorf("got %v, want an *OutsideRootError", ctx.Failures.Errors[0])
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/openpgp/armor"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+// ReadRecipients returns the ids of the keys the secret at filePath is
+// encrypted to. It does not need a private key.
+func ReadRecipients(filePath string) ([]uint64, error) {
+	secfile, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer secfile.Close()
+	return readRecipients(secfile)
+}
+
+func readRecipients(r io.Reader) ([]uint64, error) {
+	block, err := armor.Decode(r)
+	if err != nil {
+		return nil, &ArmorError{err}
+	}
+
+	var keyIds []uint64
+	packets := packet.NewReader(block.Body)
+	for {
+		p, err := packets.Next()
+		if err != nil {
+			return nil, err
+		}
+		switch p := p.(type) {
+		case *packet.EncryptedKey:
+			keyIds = append(keyIds, p.KeyId)
+		case *packet.SymmetricallyEncrypted:
+			return keyIds, nil
+		}
+	}
+}
+
+// List calls fn with a record for every secret, without decrypting them.
+func (ctx *SecureContext) List(fn func(SecretRecord) error) error {
+	return ctx.WalkSecrets(func(filePath string) error {
+		keyIds, err := ReadRecipients(filePath)
+		if err != nil {
+			return err
+		}
+		return fn(ctx.secretRecord(filePath, keyIds, nil))
+	})
+}
+
+// Inspect decrypts the secret at filePath and describes it.
+func (ctx *SecureContext) Inspect(filePath string) (*InspectRecord, error) {
+	md, err := ctx.DecryptFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := io.Copy(ioutil.Discard, md.UnverifiedBody)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.CheckSignature(md); err != nil {
+		return nil, err
+	}
+
+	return &InspectRecord{
+		SecretRecord: ctx.secretRecord(filePath, md.EncryptedToKeyIds, md),
+		Size:         size,
+	}, nil
+}
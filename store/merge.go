@@ -0,0 +1,130 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Conflict markers written by Merge3, as git writes them.
+const (
+	conflictOurs   = "<<<<<<< ours\n"
+	conflictBase   = "||||||| base\n"
+	conflictSep    = "=======\n"
+	conflictTheirs = ">>>>>>> theirs\n"
+)
+
+// mergeLines splits b into lines, keeping their line endings, so that joining
+// them gives back b.
+func mergeLines(b []byte) []string {
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// matchLines returns, for every line of base, the index of the line of other
+// it is matched with in their longest common subsequence, or -1.
+func matchLines(base, other []string) []int {
+	lcs := lcsTable(base, other)
+	matches := make([]int, len(base))
+	for i := range matches {
+		matches[i] = -1
+	}
+	i, j := 0, 0
+	for i < len(base) && j < len(other) {
+		switch {
+		case base[i] == other[j]:
+			matches[i] = j
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return matches
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge3 merges the changes from base to ours and from base to theirs, line
+// by line, like diff3. Regions changed differently on both sides are kept
+// with conflict markers, and conflicts reports how many there are.
+func Merge3(base, ours, theirs []byte) (merged []byte, conflicts int) {
+	linesO, linesA, linesB := mergeLines(base), mergeLines(ours), mergeLines(theirs)
+	matchA, matchB := matchLines(linesO, linesA), matchLines(linesO, linesB)
+
+	var buf bytes.Buffer
+	write := func(lines []string) {
+		for _, line := range lines {
+			buf.WriteString(line)
+		}
+	}
+	writeSection := func(marker string, lines []string) {
+		if buf.Len() > 0 && !bytes.HasSuffix(buf.Bytes(), []byte("\n")) {
+			buf.WriteString("\n")
+		}
+		buf.WriteString(marker)
+		write(lines)
+	}
+
+	o, a, b := 0, 0, 0
+	for o < len(linesO) || a < len(linesA) || b < len(linesB) {
+		// Lines of base kept on both sides are stable and copied as is.
+		if o < len(linesO) && matchA[o] == a && matchB[o] == b {
+			write(linesO[o : o+1])
+			o, a, b = o+1, a+1, b+1
+			continue
+		}
+
+		// Otherwise the region up to the next stable line changed on one
+		// side or both.
+		nextO, nextA, nextB := len(linesO), len(linesA), len(linesB)
+		for i := o; i < len(linesO); i++ {
+			if matchA[i] >= 0 && matchB[i] >= 0 {
+				nextO, nextA, nextB = i, matchA[i], matchB[i]
+				break
+			}
+		}
+		chunkO, chunkA, chunkB := linesO[o:nextO], linesA[a:nextA], linesB[b:nextB]
+		switch {
+		case equalLines(chunkA, chunkO):
+			write(chunkB)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			write(chunkA)
+		default:
+			conflicts++
+			writeSection(conflictOurs, chunkA)
+			writeSection(conflictBase, chunkO)
+			writeSection(conflictSep, chunkB)
+			writeSection(conflictTheirs, nil)
+		}
+		o, a, b = nextO, nextA, nextB
+	}
+	return buf.Bytes(), conflicts
+}
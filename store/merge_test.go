@@ -0,0 +1,101 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// lines joins its arguments as newline terminated lines.
+func lines(s ...string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return strings.Join(s, "\n") + "\n"
+}
+
+func TestMerge3Clean(t *testing.T) {
+	merge := func(base, ours, theirs, want string) {
+		t.Helper()
+		merged, conflicts := Merge3([]byte(base), []byte(ours), []byte(theirs))
+		if string(merged) != want || conflicts != 0 {
+			t.Errorf("merging %q and %q into %q: got %q with %d conflicts, want %q",
+				ours, theirs, base, merged, conflicts, want)
+		}
+	}
+	abc := lines("a", "b", "c")
+	merge(abc, abc, abc, abc)
+	merge(abc, lines("a", "B", "c"), abc, lines("a", "B", "c"))
+	merge(abc, abc, lines("a", "b", "C"), lines("a", "b", "C"))
+	merge(abc, lines("a", "B", "c"), lines("a", "B", "c"), lines("a", "B", "c"))
+	merge(lines("a", "b", "c", "d"), lines("A", "b", "c", "d"), lines("a", "b", "c", "D"), lines("A", "b", "c", "D"))
+	merge(abc, lines("a", "c"), abc, lines("a", "c"))
+	merge("", "", lines("new"), lines("new"))
+}
+
+func TestMerge3Conflicts(t *testing.T) {
+	base := lines("a", "b", "c", "d", "e")
+	merged, conflicts := Merge3([]byte(base), []byte(lines("A1", "b", "c", "d", "E1")), []byte(lines("A2", "b", "c", "d", "E2")))
+	if conflicts != 2 || bytes.Count(merged, []byte(conflictOurs)) != 2 {
+		t.Errorf("got %d conflicts in\n%s\nwant 2", conflicts, merged)
+	}
+
+	_, conflicts = Merge3(nil, []byte("a\n"), []byte("b\n"))
+	if conflicts != 1 {
+		t.Errorf("lines added on both sides: got %d conflicts, want 1", conflicts)
+	}
+	merged, conflicts = Merge3([]byte(base), []byte(lines("a", "c", "d", "e")), []byte(lines("a", "B", "c", "d", "e")))
+	if conflicts != 1 || !bytes.Contains(merged, []byte(conflictOurs+conflictBase+"b\n")) {
+		t.Errorf("deleted on one side and changed on the other: got %d conflicts in\n%s", conflicts, merged)
+	}
+}
+
+func ExampleMerge3() {
+	merged, conflicts := Merge3(
+		[]byte("user=admin\npassword=old"),
+		[]byte("user=admin\npassword=ours"),
+		[]byte("user=admin\npassword=theirs"),
+	)
+	fmt.Printf("%s%d conflict\n", merged, conflicts)
+	// Output:
+	// user=admin
+	// <<<<<<< ours
+	// password=ours
+	// ||||||| base
+	// password=old
+	// =======
+	// password=theirs
+	// >>>>>>> theirs
+	// 1 conflict
+}
+
+func TestDecryptBytes(t *testing.T) {
+	ctx := newTestContext(t)
+	if plaintext, err := ctx.DecryptBytes([]byte("\n")); plaintext != nil || err != nil {
+		t.Errorf("empty version: got %q, %v", plaintext, err)
+	}
+
+	writeTestSecret(t, ctx, "logins", "secret\n")
+	ciphertext, err := ioutil.ReadFile(ctx.SecretPath("logins"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext, err := ctx.DecryptBytes(ciphertext); string(plaintext) != "secret\n" || err != nil {
+		t.Errorf("got %q, %v, want the plaintext", plaintext, err)
+	}
+}
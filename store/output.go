@@ -0,0 +1,95 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/openpgp"
+)
+
+// SecretRecord describes a single encrypted secret. Its fields are shared by
+// every machine-readable record gosec prints.
+type SecretRecord struct {
+	Project    string   `json:"project,omitempty"`
+	Path       string   `json:"path"`
+	Secret     string   `json:"secret"`
+	Recipients []string `json:"recipients"`
+	Signer     string   `json:"signer,omitempty"`
+}
+
+// InspectRecord is printed by inspect.
+type InspectRecord struct {
+	SecretRecord
+	Size int64 `json:"size"`
+}
+
+// MatchOffset is the byte range of a match within a line.
+type MatchOffset struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// MatchRecord is a line found by Grep.
+type MatchRecord struct {
+	SecretRecord
+	Line    uint64        `json:"line"`
+	Text    string        `json:"text"`
+	Matches []MatchOffset `json:"matches"`
+	Context bool          `json:"context,omitempty"`
+}
+
+// CountRecord is the number of lines of a secret matched by Grep.
+type CountRecord struct {
+	SecretRecord
+	Count uint64 `json:"count"`
+}
+
+// KeyName returns the primary email address of the key with the given id, or
+// the id in hex if the key is not in keyRing.
+func KeyName(keyRing openpgp.EntityList, keyId uint64) string {
+	for _, key := range keyRing.KeysById(keyId) {
+		var emails []string
+		for _, ident := range key.Entity.Identities {
+			if ident.SelfSignature != nil && ident.SelfSignature.IsPrimaryId != nil && *ident.SelfSignature.IsPrimaryId {
+				return ident.UserId.Email
+			}
+			emails = append(emails, ident.UserId.Email)
+		}
+		if len(emails) > 0 {
+			sort.Strings(emails)
+			return emails[0]
+		}
+	}
+	return fmt.Sprintf("%016x", keyId)
+}
+
+// secretRecord builds the record for the secret at filePath. md may be nil if
+// the secret was not decrypted, in which case the signer is unknown.
+func (ctx *SecureContext) secretRecord(filePath string, keyIds []uint64, md *openpgp.MessageDetails) SecretRecord {
+	record := SecretRecord{
+		Project:    ctx.Project,
+		Path:       filePath,
+		Secret:     ctx.SecretName(filePath),
+		Recipients: []string{},
+	}
+	for _, keyId := range keyIds {
+		record.Recipients = append(record.Recipients, KeyName(ctx.PublicRing, keyId))
+	}
+	if md != nil && md.IsSigned {
+		record.Signer = KeyName(ctx.PublicRing, md.SignedByKeyId)
+	}
+	return record
+}
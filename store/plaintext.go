@@ -0,0 +1,314 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Modes of decrypted files and of the directories created to hold them.
+const (
+	PlaintextFileMode os.FileMode = 0600
+	PlaintextDirMode  os.FileMode = 0700
+)
+
+// ExtensionsFileName lists, in the project directory, the extensions of the
+// plaintext files EncryptRoot encrypts, one per line.
+const ExtensionsFileName = "extensions.conf"
+
+// AllExtensions in an extension list selects every file.
+const AllExtensions = "*"
+
+// DefaultExtensions are used when neither SecureContext.Extensions nor an
+// extensions.conf is set.
+var DefaultExtensions = []string{".txt"}
+
+// TmpfsTarget is the -to value selecting DefaultTmpfsDir.
+const TmpfsTarget = "tmpfs"
+
+// DefaultTmpfsDir returns a per-user directory on a memory backed file system
+// for the plaintext of the project at directoryRoot, so that decrypted
+// secrets never reach the disk. It prefers $XDG_RUNTIME_DIR and falls back to
+// /dev/shm. The directory is named after the base name of the project, for
+// readability, and a hash of its absolute path, so that projects with the
+// same base name do not share it.
+func DefaultTmpfsDir(directoryRoot string) (string, error) {
+	abs, err := filepath.Abs(directoryRoot)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+
+	sum := sha256.Sum256([]byte(abs))
+	name := fmt.Sprintf("%v-%x", filepath.Base(abs), sum[:6])
+	return filepath.Join(tmpfsBase(), "gosec", name), nil
+}
+
+// tmpfsBase returns the per-user directory DefaultTmpfsDir is under.
+func tmpfsBase() string {
+	if base := os.Getenv("XDG_RUNTIME_DIR"); base != "" {
+		return base
+	}
+	return filepath.Join("/dev/shm", fmt.Sprintf("gosec-%d", os.Getuid()))
+}
+
+// SetPlaintextDir sets PlaintextDir from a -to value, resolving TmpfsTarget.
+func (ctx *SecureContext) SetPlaintextDir(to string) error {
+	if to != TmpfsTarget {
+		ctx.PlaintextDir = to
+		return nil
+	}
+	dir, err := DefaultTmpfsDir(ctx.DirectoryRoot)
+	if err != nil {
+		return err
+	}
+	ctx.PlaintextDir = dir
+	ctx.privateBase = tmpfsBase()
+	return nil
+}
+
+// plaintextDir returns the directory decrypted files are written to and
+// plaintext files are encrypted from.
+func (ctx *SecureContext) plaintextDir() string {
+	if ctx.PlaintextDir != "" {
+		return ctx.PlaintextDir
+	}
+	return ctx.DirectoryRoot
+}
+
+// LegacyPlaintextError is returned by EncryptRoot for X.txt when there is a
+// files/X.gpg but no files/X.txt.gpg. Before ciphertext paths appended .gpg,
+// files/X.gpg decrypted to X.txt, so X.txt most likely holds edits of that
+// secret, and encrypting it would create a second secret beside it.
+type LegacyPlaintextError struct {
+	Path       string
+	CipherPath string
+}
+
+func (e *LegacyPlaintextError) Error() string {
+	return fmt.Sprintf("old plaintext of %v, which now decrypts to %v: move any edits there and remove it",
+		e.CipherPath, strings.TrimSuffix(e.Path, ".txt"))
+}
+
+// checkLegacyPlaintext returns a *LegacyPlaintextError if filePath is the
+// plaintext of a secret in the layout before .gpg was appended.
+func (ctx *SecureContext) checkLegacyPlaintext(filePath string) error {
+	if filepath.Ext(filePath) != ".txt" {
+		return nil
+	}
+	cipherPath := ctx.ciphertextPath(filePath)
+	if _, err := os.Stat(cipherPath); err == nil {
+		return nil
+	}
+	legacyPath := strings.TrimSuffix(cipherPath, ".txt.gpg") + ".gpg"
+	if _, err := os.Stat(legacyPath); err != nil {
+		return nil
+	}
+	return &LegacyPlaintextError{filePath, legacyPath}
+}
+
+// InsecureDirError is the warning that a plaintext directory can be accessed
+// by group or others.
+type InsecureDirError struct {
+	Dir  string
+	Mode os.FileMode
+}
+
+func (e *InsecureDirError) Error() string {
+	return fmt.Sprintf("%v is accessible by group or others (mode %#o)", e.Dir, e.Mode)
+}
+
+// UnsafeDirError is returned for a plaintext directory that another user
+// could have created or redirected, such as one under a predictable path in
+// /dev/shm.
+type UnsafeDirError struct {
+	Dir    string
+	Reason string
+}
+
+func (e *UnsafeDirError) Error() string {
+	return fmt.Sprintf("refusing to write plaintext to %v: %v", e.Dir, e.Reason)
+}
+
+// checkDirOwner returns an *UnsafeDirError unless dir is a directory, not a
+// symbolic link, owned by the current user.
+func checkDirOwner(dir string) (os.FileInfo, error) {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if reason := ownerProblem(fi, os.ModeDir, "directory"); reason != "" {
+		return nil, &UnsafeDirError{dir, reason}
+	}
+	return fi, nil
+}
+
+// ownerProblem returns why the file described by fi, as returned by Lstat,
+// cannot be trusted: it is a symbolic link, it is not of type typ, named
+// typeName, or it is owned by another user. It returns "" otherwise.
+func ownerProblem(fi os.FileInfo, typ os.FileMode, typeName string) string {
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		return "it is a symbolic link"
+	case fi.Mode()&os.ModeType != typ:
+		return "it is not a " + typeName
+	}
+	if uid, ok := fileOwner(fi); ok && uid != os.Getuid() {
+		return fmt.Sprintf("it is owned by uid %d", uid)
+	}
+	return ""
+}
+
+// preparePlaintextDir creates dir if needed and fails unless it is owned by
+// the current user. Under the private base of the tmpfs target, every
+// directory from the base down is created one at a time and must also be
+// inaccessible to group and others; elsewhere that is only warned about.
+func (ctx *SecureContext) preparePlaintextDir(dir string) error {
+	if ctx.privateBase != "" && insideDir(ctx.privateBase, dir) {
+		return preparePrivateDir(ctx.privateBase, dir)
+	}
+
+	if err := os.MkdirAll(dir, PlaintextDirMode); err != nil {
+		return err
+	}
+	fi, err := checkDirOwner(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		ctx.warn(&InsecureDirError{dir, fi.Mode().Perm()})
+	}
+	return nil
+}
+
+// preparePrivateDir creates base and the directories below it down to dir
+// without following symbolic links, failing on any that is not owned by the
+// current user or that group or others can access.
+func preparePrivateDir(base, dir string) error {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return err
+	}
+	if err := mkdirPrivate(base); err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		base = filepath.Join(base, part)
+		if err := mkdirPrivate(base); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirPrivate creates dir unless it exists and checks it is a directory of
+// the current user that group and others cannot access.
+func mkdirPrivate(dir string) error {
+	if err := os.Mkdir(dir, PlaintextDirMode); err != nil && !os.IsExist(err) {
+		return err
+	}
+	fi, err := checkDirOwner(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return &UnsafeDirError{dir, fmt.Sprintf("it is accessible by group or others (mode %#o)", fi.Mode().Perm())}
+	}
+	return nil
+}
+
+// ParseExtensions parses a comma separated extension list such as
+// "txt,.pem,p12". Extensions are given a leading dot.
+func ParseExtensions(list string) []string {
+	var extensions []string
+	for _, ext := range strings.Split(list, ",") {
+		if ext = normalizeExtension(ext); ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions
+}
+
+func normalizeExtension(ext string) string {
+	ext = strings.TrimSpace(ext)
+	if ext == "" || ext == AllExtensions || strings.HasPrefix(ext, ".") {
+		return ext
+	}
+	return "." + ext
+}
+
+// plaintextExtensions returns ctx.Extensions, the contents of the project's
+// extensions.conf or DefaultExtensions, in that order of preference.
+func (ctx *SecureContext) plaintextExtensions() ([]string, error) {
+	if len(ctx.Extensions) > 0 {
+		return ctx.Extensions, nil
+	}
+
+	fp, err := os.Open(path.Join(ctx.DirectoryRoot, ExtensionsFileName))
+	if os.IsNotExist(err) {
+		return DefaultExtensions, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var extensions []string
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if ext := normalizeExtension(line); ext != "" {
+			extensions = append(extensions, ext)
+		}
+	}
+	return extensions, scanner.Err()
+}
+
+// isPlaintext reports whether EncryptRoot encrypts the file at filePath: its
+// extension is selected, or it already has an encrypted counterpart. Files
+// that are themselves encrypted, left behind by a conflict or that configure
+// the project never are.
+func (ctx *SecureContext) isPlaintext(filePath string, extensions []string) bool {
+	ext := filepath.Ext(filePath)
+	if ext == ".gpg" || ext == ConflictSuffix {
+		return false
+	}
+	if filepath.Dir(filePath) == filepath.Clean(ctx.DirectoryRoot) {
+		switch filepath.Base(filePath) {
+		case AccessListFileName, ExtensionsFileName, GitignoreFileName, IgnoreFileName, StateFileName, WritersFileName:
+			return false
+		}
+	}
+	for _, e := range extensions {
+		if e == AllExtensions || e == ext {
+			return true
+		}
+	}
+	_, err := os.Stat(ctx.ciphertextPath(filePath))
+	return err == nil
+}
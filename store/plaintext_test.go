@@ -0,0 +1,94 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultTmpfsDir(t *testing.T) {
+	runtimeDir := t.TempDir()
+	old, had := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", runtimeDir)
+	defer func() {
+		if had {
+			os.Setenv("XDG_RUNTIME_DIR", old)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	root := t.TempDir()
+	a, b := filepath.Join(root, "a", "secrets"), filepath.Join(root, "b", "secrets")
+	for _, dir := range []string{a, b} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	link := filepath.Join(root, "link")
+	if err := os.Symlink(a, link); err != nil {
+		t.Fatal(err)
+	}
+
+	dirA, err := DefaultTmpfsDir(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirB, _ := DefaultTmpfsDir(b)
+	dirLink, _ := DefaultTmpfsDir(link)
+	if dirA == dirB {
+		t.Errorf("projects with the same base name share %v", dirA)
+	}
+	if dirLink != dirA {
+		t.Errorf("the same project through a symlink: got %v, want %v", dirLink, dirA)
+	}
+	for _, dir := range []string{dirA, dirB} {
+		if filepath.Dir(dir) != filepath.Join(runtimeDir, "gosec") || !strings.HasPrefix(filepath.Base(dir), "secrets-") {
+			t.Errorf("got %v, want %v/gosec/secrets-<hash>", dir, runtimeDir)
+		}
+	}
+}
+
+func TestLegacyPlaintext(t *testing.T) {
+	ctx := newTestContext(t)
+	writeTestSecret(t, ctx, "logins", "upstream\n")
+	legacyPath := filepath.Join(ctx.DirectoryRoot, "logins.txt")
+	writeTestFile(t, legacyPath, "old edit\n")
+
+	var warnings []error
+	ctx.Warn = func(err error) { warnings = append(warnings, err) }
+	if err := ctx.DecryptRoot(); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(ctx.DirectoryRoot, "logins")); err != nil || string(b) != "upstream\n" {
+		t.Errorf("files/logins.gpg decrypted to %q, %v, want logins", b, err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("got warnings %v, want one for %v", warnings, legacyPath)
+	}
+	if _, ok := fileErr(warnings[0]).(*LegacyPlaintextError); !ok {
+		t.Errorf("got warning %v, want a *LegacyPlaintextError", warnings[0])
+	}
+
+	if _, ok := fileErr(ctx.EncryptRoot()).(*LegacyPlaintextError); !ok {
+		t.Error("-e encrypted old plaintext")
+	}
+	if _, err := os.Stat(ctx.SecretPath("logins.txt")); !os.IsNotExist(err) {
+		t.Errorf("second secret files/logins.txt.gpg created")
+	}
+}
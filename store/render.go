@@ -0,0 +1,101 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"text/template"
+)
+
+// SecretCache decrypts secrets, referred to as in FindSecret, at most once.
+type SecretCache struct {
+	ctx        *SecureContext
+	plaintexts map[string][]byte
+	fields     map[string]Fields
+}
+
+// NewSecretCache returns a cache decrypting secrets with the keys of ctx,
+// whatever their project.
+func NewSecretCache(ctx *SecureContext) *SecretCache {
+	return &SecretCache{
+		ctx:        ctx,
+		plaintexts: map[string][]byte{},
+		fields:     map[string]Fields{},
+	}
+}
+
+// Secret returns the plaintext of the secret ref.
+func (c *SecretCache) Secret(ref string) ([]byte, error) {
+	if plaintext, ok := c.plaintexts[ref]; ok {
+		return plaintext, nil
+	}
+	dir, name, err := FindSecret(ref)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.ctx.ForProject(Project{Dir: dir}).Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ref, err)
+	}
+	defer r.Close()
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", ref, err)
+	}
+	c.plaintexts[ref] = plaintext
+	return plaintext, nil
+}
+
+// Field returns the value of the field at path of the structured secret ref.
+func (c *SecretCache) Field(ref, path string) (string, error) {
+	fields, ok := c.fields[ref]
+	if !ok {
+		plaintext, err := c.Secret(ref)
+		if err != nil {
+			return "", err
+		}
+		if fields, err = ParseFields(plaintext); err != nil {
+			return "", fmt.Errorf("%v: %v", ref, err)
+		}
+		c.fields[ref] = fields
+	}
+	field, err := fields.Lookup(path)
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", ref, err)
+	}
+	if field.Fields != nil {
+		return "", fmt.Errorf("%v: field %q is a block, not a value", ref, path)
+	}
+	return field.Value, nil
+}
+
+// FuncMap returns the template functions reading from the cache:
+//
+//	{{ secret "project1/cloud" }}            the whole secret
+//	{{ secret "project1/cloud" "api_key" }}  a field of a structured secret
+func (c *SecretCache) FuncMap() template.FuncMap {
+	return template.FuncMap{
+		"secret": func(ref string, path ...string) (string, error) {
+			switch len(path) {
+			case 0:
+				plaintext, err := c.Secret(ref)
+				return string(plaintext), err
+			case 1:
+				return c.Field(ref, path[0])
+			}
+			return "", fmt.Errorf("secret takes a secret and at most one field")
+		},
+	}
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// StateFileName is the file, in the project directory, recording what each
+// secret looked like when it was last decrypted or encrypted.
+const StateFileName = ".gosec-state"
+
+// DefaultStateKeyPath holds the key used to hash plaintext in state files,
+// so that a state file alone does not allow guessing short secrets.
+var DefaultStateKeyPath = "~/.gosec/state.key"
+
+// SecretState is the last synchronized version of a secret: a SHA-256 of its
+// ciphertext and an HMAC-SHA256 of its plaintext.
+type SecretState struct {
+	Ciphertext string `json:"ciphertext"`
+	Plaintext  string `json:"plaintext"`
+}
+
+// SyncState tracks the last synchronized version of every secret of a
+// project, to tell local plaintext edits from upstream ciphertext changes.
+type SyncState struct {
+	Secrets map[string]SecretState `json:"secrets"`
+
+	path string
+	key  []byte
+}
+
+// readStateKey returns the state key, creating it on first use.
+func readStateKey() ([]byte, error) {
+	keyPath, err := expandPath(DefaultStateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ioutil.ReadFile(keyPath)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	err = WriteFileAtomic(keyPath, 0600, func(w io.Writer) error {
+		_, err := w.Write(key)
+		return err
+	})
+	return key, err
+}
+
+// LoadState reads the project's state file. A missing file yields an empty
+// state.
+func (ctx *SecureContext) LoadState() (*SyncState, error) {
+	return loadSyncState(path.Join(ctx.DirectoryRoot, StateFileName))
+}
+
+// loadSyncState reads the state file at statePath. A missing file yields an
+// empty state.
+func loadSyncState(statePath string) (*SyncState, error) {
+	key, err := readStateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &SyncState{
+		Secrets: map[string]SecretState{},
+		path:    statePath,
+		key:     key,
+	}
+	b, err := ioutil.ReadFile(state.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, state); err != nil {
+		return nil, err
+	}
+	if state.Secrets == nil {
+		state.Secrets = map[string]SecretState{}
+	}
+	return state, nil
+}
+
+// Save writes the state file.
+func (state *SyncState) Save() error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(state.path, 0600, func(w io.Writer) error {
+		_, err := w.Write(append(b, '\n'))
+		return err
+	})
+}
+
+func (state *SyncState) hashCiphertext(ciphertext []byte) string {
+	sum := sha256.Sum256(ciphertext)
+	return hex.EncodeToString(sum[:])
+}
+
+func (state *SyncState) hashPlaintext(plaintext []byte) string {
+	mac := hmac.New(sha256.New, state.key)
+	mac.Write(plaintext)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Record marks ciphertext and plaintext as the synchronized version of name.
+func (state *SyncState) Record(name string, ciphertext, plaintext []byte) {
+	state.Secrets[name] = SecretState{
+		Ciphertext: state.hashCiphertext(ciphertext),
+		Plaintext:  state.hashPlaintext(plaintext),
+	}
+}
+
+// LocalChanged reports whether plaintext differs from the last synchronized
+// plaintext of name, or whether name was never synchronized.
+func (state *SyncState) LocalChanged(name string, plaintext []byte) bool {
+	entry, ok := state.Secrets[name]
+	return !ok || !hmac.Equal([]byte(entry.Plaintext), []byte(state.hashPlaintext(plaintext)))
+}
+
+// UpstreamChanged reports whether ciphertext differs from the last
+// synchronized ciphertext of name. A secret that was never synchronized, such
+// as every secret of a project decrypted before state files existed, is
+// taken as unchanged: plaintext that differs from it is then a local edit,
+// which -e encrypts and -d keeps, rather than a conflict.
+func (state *SyncState) UpstreamChanged(name string, ciphertext []byte) bool {
+	entry, ok := state.Secrets[name]
+	return ok && entry.Ciphertext != state.hashCiphertext(ciphertext)
+}
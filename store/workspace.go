@@ -0,0 +1,113 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Project is a directory holding an access-list.conf and a files directory.
+type Project struct {
+	Name string
+	Dir  string
+}
+
+// IsProject reports whether dir looks like a gosec project.
+func IsProject(dir string) bool {
+	for _, name := range []string{"files", AccessListFileName} {
+		if _, err := os.Stat(path.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// FindProjects returns every project below root, named by their path relative
+// to root. It does not descend into projects or hidden directories.
+// Directories that cannot be read are recorded in failures, when it is not
+// nil, and skipped instead of ending the walk.
+func FindProjects(root string, failures *FailureLog) ([]Project, error) {
+	var projects []Project
+	err := filepath.Walk(root, func(dir string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if failures == nil {
+				return err
+			}
+			failures.Errors = append(failures.Errors, &FileError{dir, err})
+			return nil
+		}
+		if !fi.IsDir() {
+			return nil
+		}
+		if dir != root && strings.HasPrefix(fi.Name(), ".") {
+			return filepath.SkipDir
+		}
+		if !IsProject(dir) {
+			return nil
+		}
+
+		name, err := filepath.Rel(root, dir)
+		if err != nil {
+			return err
+		}
+		projects = append(projects, Project{filepath.ToSlash(name), dir})
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Name < projects[j].Name
+	})
+	return projects, nil
+}
+
+// ReadWorkspaceFile reads a list of project directories, one per line, with
+// # comments. Relative directories are resolved against the file's directory.
+func ReadWorkspaceFile(workspaceFile string) ([]Project, error) {
+	fp, err := os.Open(workspaceFile)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+
+	var projects []Project
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		dir := line
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(workspaceFile), dir)
+		}
+		projects = append(projects, Project{filepath.ToSlash(filepath.Clean(line)), dir})
+	}
+	return projects, scanner.Err()
+}
+
+// ForProject returns a copy of ctx for project, sharing its key rings.
+func (ctx *SecureContext) ForProject(project Project) *SecureContext {
+	projectCtx := *ctx
+	projectCtx.DirectoryRoot = project.Dir
+	projectCtx.Project = project.Name
+	return &projectCtx
+}
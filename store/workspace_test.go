@@ -0,0 +1,73 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindProjects(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{
+		"a/" + AccessListFileName,
+		"b/c/files/x.gpg",
+		"b/c/nested/" + AccessListFileName,
+		".hidden/" + AccessListFileName,
+		"empty/README",
+	} {
+		writeTestFile(t, filepath.Join(root, file), "")
+	}
+
+	projects, err := FindProjects(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Project{{"a", filepath.Join(root, "a")}, {"b/c", filepath.Join(root, "b/c")}}
+	if !reflect.DeepEqual(projects, want) {
+		t.Errorf("got %v, want %v", projects, want)
+	}
+}
+
+func TestFindProjectsUnreadable(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can read any directory")
+	}
+	root := t.TempDir()
+	writeTestFile(t, filepath.Join(root, "a", AccessListFileName), "")
+	writeTestFile(t, filepath.Join(root, "locked", "b", AccessListFileName), "")
+	locked := filepath.Join(root, "locked")
+	if err := os.Chmod(locked, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(locked, 0700)
+
+	if _, err := FindProjects(root, nil); err == nil {
+		t.Error("without a failure log: got no error")
+	}
+
+	failures := &FailureLog{}
+	projects, err := FindProjects(root, failures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projects) != 1 || projects[0].Name != "a" {
+		t.Errorf("got %v, want project a", projects)
+	}
+	if len(failures.Errors) != 1 || failures.Errors[0].Path != locked {
+		t.Errorf("got failures %v, want %v", failures.Errors, locked)
+	}
+}
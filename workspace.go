@@ -0,0 +1,73 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"errors"
+	"flag"
+
+	"github.com/rphillips/gosec/store"
+)
+
+var errNoRoot = errors.New("Root directory must be specified")
+
+// projectFlags are the flags selecting the projects a command runs on: a
+// single project with -s, or every project of a workspace.
+type projectFlags struct {
+	directoryRoot string
+	workspace     string
+	workspaceFile string
+}
+
+func newProjectFlags(fs *flag.FlagSet) *projectFlags {
+	pf := &projectFlags{}
+	fs.StringVar(&pf.directoryRoot, "s", "", "Directory")
+	fs.StringVar(&pf.workspace, "workspace", "", "Use every project below `DIR`")
+	fs.StringVar(&pf.workspaceFile, "workspace-file", "", "Use the projects listed in `FILE`")
+	return pf
+}
+
+// Projects returns the selected projects. Only workspace projects are named,
+// so that single project output is unchanged. Directories of a workspace that
+// cannot be read are recorded in failures, when it is not nil, instead of
+// failing. A workspace without projects is an error.
+func (pf *projectFlags) Projects(failures *store.FailureLog) ([]store.Project, error) {
+	var projects []store.Project
+	var err error
+	switch {
+	case pf.workspace != "":
+		projects, err = store.FindProjects(pf.workspace, failures)
+		if err == nil && len(projects) == 0 {
+			err = errors.New("no projects found in " + pf.workspace)
+		}
+	case pf.workspaceFile != "":
+		projects, err = store.ReadWorkspaceFile(pf.workspaceFile)
+		if err == nil && len(projects) == 0 {
+			err = errors.New("no projects found in " + pf.workspaceFile)
+		}
+	case pf.directoryRoot != "":
+		projects = []store.Project{{Dir: pf.directoryRoot}}
+	default:
+		err = errNoRoot
+	}
+	return projects, err
+}
+
+// prefixed prepends the project name to s when running in a workspace.
+func prefixed(record store.SecretRecord, s string) string {
+	if record.Project == "" {
+		return s
+	}
+	return record.Project + ": " + s
+}
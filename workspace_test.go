@@ -0,0 +1,39 @@
+// Copyright 2015 Ryan Phillips. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProjectsEmptyWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := (&projectFlags{workspace: dir}).Projects(nil); err == nil {
+		t.Error("-workspace without projects: got no error")
+	}
+
+	workspaceFile := filepath.Join(dir, "workspace.conf")
+	if err := os.WriteFile(workspaceFile, []byte("# nothing yet\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&projectFlags{workspaceFile: workspaceFile}).Projects(nil); err == nil {
+		t.Error("-workspace-file without projects: got no error")
+	}
+
+	if _, err := (&projectFlags{}).Projects(nil); err != errNoRoot {
+		t.Errorf("no flag: got %v, want %v", err, errNoRoot)
+	}
+}